@@ -2,4 +2,4 @@ module prototest
 
 go 1.23.3
 
-require google.golang.org/protobuf v1.35.2 // indirect
+require google.golang.org/protobuf v1.35.2
@@ -7,11 +7,17 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"prototest/pt"
 )
 
 const (
@@ -22,10 +28,120 @@ const (
 type pData struct {
 	// 각 필드가 JSON으로 변환될 때 어떤 키로 매핑되는지 명시
 	// + 만약 JSON 태그를 생략하면, 구조체 필드 이름이 그대로 JSON 키로 사용
-	Id      int    `json:"id"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Sex     string `json:"sex"`
+	Id         int64             `json:"id,omitempty"` // 생략하면 (-server_ids) Tx 서버가 할당
+	Name       string            `json:"name"`
+	Address    string            `json:"address"`
+	Sex        string            `json:"sex"`                  // "Male", "Female", "Other"
+	Version    int64             `json:"version,omitempty"`    // PUT/DELETE 시 기대하는 레코드 버전 (0이면 생략)
+	CreatedAt  *time.Time        `json:"created_at,omitempty"` // Tx 서버가 관리 (보내지 않음)
+	UpdatedAt  *time.Time        `json:"updated_at,omitempty"` // Tx 서버가 관리 (보내지 않음)
+	Attributes map[string]string `json:"attributes,omitempty"`
+	TTL        string            `json:"ttl,omitempty"` // 지정하면 이 시간 후 Tx 서버가 자동 삭제 (예: "30s")
+}
+
+// -ttl: 생성/수정하는 데이터의 만료 시간
+var ttl string
+
+// -legacy_json: Tx 서버가 -legacy_json으로 실행 중이면 예전 형식 (pData 배열)으로 보냄
+var legacyJSON bool
+
+// Tx 서버의 표준 JSON과 같은 옵션 (필드 이름은 .proto 그대로, 빈 필드는 생략)
+var canonicalJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+var sexValues = map[string]pt.Sex{
+	"Male":   pt.Sex_SEX_MALE,
+	"Female": pt.Sex_SEX_FEMALE,
+	"Other":  pt.Sex_SEX_OTHER,
+}
+
+// 요청 본문 -> ttl은 표준 형식에 없는 요청 전용 필드라 레코드 옆에 덧붙여 보냄 (만료 시각은 Tx 서버가 받은 시점 기준)
+func marshalData(data []pData) ([]byte, error) {
+	if legacyJSON {
+		return json.Marshal(data)
+	}
+	buf := []byte{'['}
+	for i, p := range data {
+		d := &pt.Data{
+			Id:         p.Id,
+			Name:       p.Name,
+			Address:    p.Address,
+			Sex:        sexValues[p.Sex],
+			Version:    p.Version,
+			Attributes: p.Attributes,
+		}
+		record, err := canonicalJSON.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		if p.TTL != "" {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(record, &fields); err != nil {
+				return nil, err
+			}
+			fields["ttl"], _ = json.Marshal(p.TTL)
+			if record, err = json.Marshal(fields); err != nil {
+				return nil, err
+			}
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, record...)
+	}
+	return append(buf, ']'), nil
+}
+
+// -attrs "team=a,env=test" -> 생성/수정하는 데이터에 추가 속성으로 붙임
+var attributes map[string]string
+
+func parseAttributes(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	attrs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid attribute %q (expected key=value)", pair)
+		}
+		attrs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return attrs, nil
+}
+
+// 쓰기 요청에 대한 Tx 서버의 응답 (항목별 처리 결과)
+type itemResult struct {
+	Id      int64  `json:"id"`
+	Outcome string `json:"outcome"` // created, updated, deleted, restored, not_found, invalid, ...
+	Error   string `json:"error"`
+}
+
+type writeResponse struct {
+	Mode    string         `json:"mode"`
+	Version uint64         `json:"version"` // 처리 후의 데이터셋 버전
+	Count   int            `json:"count"`
+	Summary map[string]int `json:"summary"`
+	Results []itemResult   `json:"results"`
+	Error   *apiError      `json:"error"` // 실패 상태 코드일 때 (요청 자체가 잘못되었으면 results 없이 error만)
+}
+
+// Tx 서버의 오류 형식 -> {"error": {"code": ..., "message": ..., "details": ..., "request_id": ...}}
+type apiError struct {
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	Details   json.RawMessage `json:"details"`
+	RequestId string          `json:"request_id"`
+}
+
+func (e *apiError) Error() string {
+	msg := e.Code + ": " + e.Message
+	if len(e.Details) > 0 {
+		msg += " " + string(e.Details)
+	}
+	if e.RequestId != "" {
+		msg += " (request " + e.RequestId + ")" // 서버 로그에서 찾을 때 사용
+	}
+	return msg
 }
 
 // -pro=https인 경우 대비
@@ -35,6 +151,9 @@ var client = &http.Client{
 	},
 }
 
+// true면 ID 없이 생성 -> Tx 서버가 ID를 할당하고 응답으로 알려줌
+var serverIds bool
+
 func generateData(n int) []pData {
 	// []pData: pData 구조체 타입의 슬라이스 (크기 가변적) -> 여러 개의 구조체 담기
 	/* 예시
@@ -46,15 +165,87 @@ func generateData(n int) []pData {
 	data := make([]pData, n)
 	for i := 1; i <= n; i++ {
 		data[i-1] = pData{
-			Id:      i,
-			Name:    fmt.Sprintf("Alex%d", i),
-			Address: DefaultAddress,
-			Sex:     DefaultSex,
+			Name:       fmt.Sprintf("Alex%d", i),
+			Address:    DefaultAddress,
+			Sex:        DefaultSex,
+			Attributes: attributes,
+			TTL:        ttl,
+		}
+		if !serverIds {
+			data[i-1].Id = int64(i)
 		}
 	}
 	return data
 }
 
+// 쓰기 방식(replace, append, upsert, update, merge)과 중복 ID 처리(first, last, reject)를 쿼리로 붙임
+// -> 빈 값이면 서버의 메서드별 기본값 사용
+var writeMode, dupPolicy string
+
+// If-Match 헤더로 보낼 데이터셋 ETag (예: "v3") -> 그 사이에 다른 클라이언트가 수정했으면 412
+var ifMatch string
+
+// X-Client-Id 헤더로 보낼 이름 -> Tx 서버의 변경 이력(/history)에 누가 바꿨는지 기록됨
+var clientId string
+
+func defaultClientId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "provider"
+	}
+	return "provider@" + host
+}
+
+func withWriteOptions(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %v", err)
+	}
+	q := u.Query()
+	if writeMode != "" {
+		q.Set("mode", writeMode)
+	}
+	if dupPolicy != "" {
+		q.Set("dup", dupPolicy)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Tx 서버 URL에 경로를 붙임 (예: http://localhost:8080 + undelete -> http://localhost:8080/undelete)
+func endpointURL(rawURL, endpoint string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %v", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + endpoint
+	return u.String(), nil
+}
+
+// -collection: 데이터를 보낼 Tx 서버의 컬렉션 (비어 있으면 기본 컬렉션)
+var collection string
+
+// 컬렉션의 엔드포인트 URL (예: batch -> http://localhost:8080/collections/team-a/batch)
+// 컬렉션을 지정하지 않으면 기존 경로 사용 (batch는 Tx 서버 URL 그대로)
+func collectionURL(txURL, endpoint string) (string, error) {
+	if collection == "" {
+		if endpoint == "batch" {
+			return txURL, nil
+		}
+		return endpointURL(txURL, endpoint)
+	}
+	return endpointURL(txURL, "collections/"+url.PathEscape(collection)+"/"+endpoint)
+}
+
+// 삭제된 데이터를 되살림 (Tx 서버는 삭제 후 보존 기간 동안 툼스톤으로 보관)
+func sendUndelete(txURL string, data []pData) error {
+	undeleteURL, err := collectionURL(txURL, "undelete")
+	if err != nil {
+		return err
+	}
+	return sendRequest("POST", undeleteURL, data)
+}
+
 // 요청을 보낼 때 -> 데이터 목록 전체를 JSON 배열로 묶어 한 번에 보내도록 수정!
 func sendRequest(method, url string, data []pData) error {
 	// 배열을 JSON 형식으로 직렬화
@@ -75,11 +266,16 @@ func sendRequest(method, url string, data []pData) error {
 			  }
 			]
 	*/
-	jsonData, err := json.Marshal(data)
+	jsonData, err := marshalData(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %v", err)
 	}
 
+	url, err = withWriteOptions(url)
+	if err != nil {
+		return err
+	}
+
 	// JSON 데이터를 HTTP 요청 본문으로 추가: http.NewRequest는 https 지원
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -87,28 +283,148 @@ func sendRequest(method, url string, data []pData) error {
 	}
 	//💡서버에게 요청 본문이 JSON 형식임을 알림 (필수 X)(명확성 -> 서버와의 원활한 의사소통, 에러 발생 가능성 감소)
 	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if clientId != "" {
+		req.Header.Set("X-Client-Id", clientId)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %v", err)
 	}
 	defer resp.Body.Close()
+	version, err := readWriteResponse(resp)
+	if err != nil || rxURL == "" || version == 0 {
+		return err
+	}
+	return waitForRx(version)
+}
+
+// -rx_url: 쓰기 응답의 버전이 Rx에 반영될 때까지 기다렸다가 반환 (Rx의 GET /data?min_version=&wait=)
+// -> 다음 요청이나 viewer가 Rx에서 방금 쓴 데이터를 볼 수 있음
+var rxURL string
+var rxWait time.Duration
+
+func waitForRx(version uint64) error {
+	dataURL, err := collectionURL(rxURL, "data")
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(dataURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	q := u.Query()
+	q.Set("min_version", strconv.FormatUint(version, 10))
+	q.Set("wait", rxWait.String())
+	q.Set("limit", "1") // 데이터는 필요 없음
+	u.RawQuery = q.Encode()
+
+	start := time.Now()
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("request to Rx failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var errResp struct {
+			Error *apiError `json:"error"`
+		}
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != nil {
+			return fmt.Errorf("version %d not visible on Rx: %v", version, errResp.Error)
+		}
+		return fmt.Errorf("version %d not visible on Rx: %s: %s", version, resp.Status, strings.TrimSpace(string(body)))
+	}
+	fmt.Printf("  visible on Rx (version %d) after %d ms\n", version, time.Since(start).Milliseconds())
 	return nil
 }
 
+// 응답 본문의 항목별 결과를 출력하고, 커밋된 데이터셋 버전 반환 (실패 상태 코드면 에러)
+func readWriteResponse(resp *http.Response) (uint64, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response: %v", err)
+	}
+	var result writeResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		if resp.StatusCode >= 400 {
+			return 0, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return 0, nil
+	}
+	if result.Error != nil && result.Results == nil {
+		return 0, fmt.Errorf("server returned %s: %v", resp.Status, result.Error)
+	}
+
+	fmt.Printf("Server response: %s, version=%d, count=%d, summary=%v\n", resp.Status, result.Version, result.Count, result.Summary)
+	if serverIds {
+		// 서버가 할당한 ID 범위 출력
+		var first, last int64
+		for _, res := range result.Results {
+			if res.Outcome == "created" {
+				if first == 0 {
+					first = res.Id
+				}
+				last = res.Id
+			}
+		}
+		if first != 0 {
+			fmt.Printf("  assigned IDs: %d..%d\n", first, last)
+		}
+	}
+	for _, res := range result.Results {
+		if res.Error != "" { // 실패한 항목만 출력 (성공한 항목까지 출력하면 POST n개일 때 너무 많음)
+			fmt.Printf("  ID %d: %s (%s)\n", res.Id, res.Outcome, res.Error)
+		}
+	}
+	if result.Error != nil {
+		return 0, fmt.Errorf("server returned %s: %v", resp.Status, result.Error)
+	}
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return result.Version, nil
+}
+
 func main() {
-	method := flag.String("m", "", "Request Method to Server (POST, PUT, DELETE)")
+	method := flag.String("m", "", "Request Method to Server (POST, PUT, DELETE, UNDELETE)")
 	url := flag.String("tx_url", "", "Tx Server URL")
 	// 메서드에 따라서 추가 명령행 인자
 	n := flag.Int("n", 0, "Number of data to generate (for POST)")
-	id := flag.Int("id", 0, "ID of the entry (for PUT/DELETE)")
+	id := flag.Int64("id", 0, "ID of the entry (for PUT/DELETE/UNDELETE)")
 	name := flag.String("name", "", "Name to update (for PUT)")
+	flag.StringVar(&writeMode, "write_mode", "", "Write mode (replace, append, upsert, update, merge)")
+	flag.StringVar(&dupPolicy, "dup", "", "Duplicate ID policy within a request (first, last, reject)")
+	flag.BoolVar(&serverIds, "server_ids", false, "Let the Tx server assign IDs (for POST)")
+	flag.StringVar(&ifMatch, "if_match", "", "Dataset ETag to send as If-Match (e.g. \"v3\")")
+	version := flag.Int64("version", 0, "Expected record version (for PUT/DELETE/UNDELETE)")
+	flag.BoolVar(&legacyJSON, "legacy_json", false, "Send the old JSON shape (for Tx servers started with -legacy_json)")
+	flag.StringVar(&ttl, "ttl", "", "Expire the data after this duration, e.g. 30s or 10m (for POST/PUT)")
+	flag.StringVar(&collection, "collection", "", "Collection on the Tx server (default collection if empty)")
+	flag.StringVar(&rxURL, "rx_url", "", "Rx Server URL: after each write, wait until Rx has applied the committed version")
+	flag.DurationVar(&rxWait, "rx_wait", 30*time.Second, "How long to wait for Rx with -rx_url (up to 1m)")
+	flag.StringVar(&clientId, "client_id", defaultClientId(), "Name recorded in the Tx server's change history")
+	attrs := flag.String("attrs", "", "Extra attributes as key=value pairs separated by commas (for POST/PUT)")
 	flag.Parse()
 
+	var err error
+	if attributes, err = parseAttributes(*attrs); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	if *url == "" {
 		fmt.Println("Error: Tx server url must be specified.")
 		os.Exit(1)
 	}
+	dataURL, err := collectionURL(*url, "batch") // JSON 배열로 여러 개를 한 번에 보내는 경로
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	switch strings.ToUpper(*method) {
 	case "POST":
@@ -120,7 +436,7 @@ func main() {
 		start := time.Now()
 		dataList := generateData(*n)
 		// POST 요청을 한 번에 전체 데이터 배열로 보냄
-		err := sendRequest("POST", *url, dataList)
+		err := sendRequest("POST", dataURL, dataList)
 		end := time.Since(start)
 		fmt.Printf("-- Provider: Time elapsed for POST request: %d ms.\n", end.Milliseconds())
 		if err != nil {
@@ -133,12 +449,15 @@ func main() {
 		}
 		start := time.Now()
 		data := pData{
-			Id:      *id,
-			Name:    *name,
-			Address: DefaultAddress,
-			Sex:     DefaultSex,
+			Id:         *id,
+			Name:       *name,
+			Address:    DefaultAddress,
+			Sex:        DefaultSex,
+			Version:    *version,
+			Attributes: attributes,
+			TTL:        ttl,
 		}
-		err := sendRequest("PUT", *url, []pData{data})
+		err := sendRequest("PUT", dataURL, []pData{data})
 		end := time.Since(start)
 		fmt.Printf("-- Provider: Time elapsed for PUT request: %d ms.\n", end.Milliseconds())
 		if err != nil {
@@ -151,23 +470,40 @@ func main() {
 		}
 		start := time.Now()
 		data := pData{ // data는 pData 타입의 단일 구조체
-			Id: *id,
+			Id:      *id,
+			Version: *version,
 		}
-		err := sendRequest("DELETE", *url, []pData{data}) //  []pData{data}: 해당 구조체를 하나의 요소로 가진 슬라이스
+		err := sendRequest("DELETE", dataURL, []pData{data}) //  []pData{data}: 해당 구조체를 하나의 요소로 가진 슬라이스
 		end := time.Since(start)
 		fmt.Printf("-- Provider: Time elapsed for DELETE request: %d ms.\n", end.Milliseconds())
 		if err != nil {
 			fmt.Printf("Error sending DELETE request for ID %d: %v\n", data.Id, err)
 		}
+	case "UNDELETE":
+		if *id <= 0 {
+			fmt.Println("Error: UNDELETE requires id.")
+			os.Exit(1)
+		}
+		start := time.Now()
+		data := pData{
+			Id:      *id,
+			Version: *version,
+		}
+		err := sendUndelete(*url, []pData{data})
+		end := time.Since(start)
+		fmt.Printf("-- Provider: Time elapsed for UNDELETE request: %d ms.\n", end.Milliseconds())
+		if err != nil {
+			fmt.Printf("Error sending UNDELETE request for ID %d: %v\n", data.Id, err)
+		}
 	default:
-		fmt.Println("Error: Invalid method. Use POST, PUT, or DELETE.")
+		fmt.Println("Error: Invalid method. Use POST, PUT, DELETE, or UNDELETE.")
 		os.Exit(1)
 	}
 
 	// 명령행 인자로 지정한 작업 진행 후, 메서드 입력하도록
 	reader := bufio.NewReader(os.Stdin)
 	for {
-		fmt.Println("Type a method (POST,PUT,DELETE) or 'exit' to quit.")
+		fmt.Println("Type a method (POST,PUT,DELETE,UNDELETE) or 'exit' to quit.")
 		fmt.Print(">> ")
 
 		// ReadString reads until the first occurrence of delim in the input
@@ -195,7 +531,7 @@ func main() {
 			start := time.Now()
 			dataList := generateData(n)
 			// POST 요청을 한 번에 전체 데이터 배열로 보냄
-			err = sendRequest("POST", *url, dataList)
+			err = sendRequest("POST", dataURL, dataList)
 			end := time.Since(start)
 			fmt.Printf("-- Provider: Time elapsed for POST request: %d ms.\n", end.Milliseconds())
 			if err != nil {
@@ -205,7 +541,7 @@ func main() {
 			fmt.Print("Enter ID to update: ")
 			idStr, _ := reader.ReadString('\n')
 			idStr = strings.TrimSpace(idStr)
-			id, err := strconv.Atoi(idStr)
+			id, err := strconv.ParseInt(idStr, 10, 64)
 			if err != nil || id <= 0 {
 				fmt.Println("Invalid ID.")
 				continue
@@ -219,12 +555,14 @@ func main() {
 			}
 			start := time.Now()
 			data := pData{
-				Id:      id,
-				Name:    name,
-				Address: DefaultAddress,
-				Sex:     DefaultSex,
+				Id:         id,
+				Name:       name,
+				Address:    DefaultAddress,
+				Sex:        DefaultSex,
+				Attributes: attributes,
+				TTL:        ttl,
 			}
-			err = sendRequest("PUT", *url, []pData{data})
+			err = sendRequest("PUT", dataURL, []pData{data})
 			end := time.Since(start)
 			fmt.Printf("-- Provider: Time elapsed for PUT request: %d ms.\n", end.Milliseconds())
 			if err != nil {
@@ -234,7 +572,7 @@ func main() {
 			fmt.Print("Enter ID to delete: ")
 			idStr, _ := reader.ReadString('\n')
 			idStr = strings.TrimSpace(idStr)
-			id, err := strconv.Atoi(idStr)
+			id, err := strconv.ParseInt(idStr, 10, 64)
 			if err != nil || id <= 0 {
 				fmt.Println("Invalid ID.")
 				continue
@@ -243,14 +581,33 @@ func main() {
 			data := pData{
 				Id: id,
 			}
-			err = sendRequest("DELETE", *url, []pData{data})
+			err = sendRequest("DELETE", dataURL, []pData{data})
 			end := time.Since(start)
 			fmt.Printf("-- Provider: Time elapsed for DELETE request: %d ms.\n", end.Milliseconds())
 			if err != nil {
 				fmt.Printf("Error sending DELETE request for ID %d: %v\n", data.Id, err)
 			}
+		} else if strings.ToUpper(input) == "UNDELETE" {
+			fmt.Print("Enter ID to undelete: ")
+			idStr, _ := reader.ReadString('\n')
+			idStr = strings.TrimSpace(idStr)
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil || id <= 0 {
+				fmt.Println("Invalid ID.")
+				continue
+			}
+			start := time.Now()
+			data := pData{
+				Id: id,
+			}
+			err = sendUndelete(*url, []pData{data})
+			end := time.Since(start)
+			fmt.Printf("-- Provider: Time elapsed for UNDELETE request: %d ms.\n", end.Milliseconds())
+			if err != nil {
+				fmt.Printf("Error sending UNDELETE request for ID %d: %v\n", data.Id, err)
+			}
 		} else {
-			fmt.Println("Error: Invalid method. Use POST, PUT, or DELETE.")
+			fmt.Println("Error: Invalid method. Use POST, PUT, DELETE, or UNDELETE.")
 			os.Exit(1)
 		}
 	}
@@ -1,233 +1,5093 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"maps"
+	"math"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"prototest/pt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
-	//"sync"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	httpPort  = "8080"
+	httpsPort = "8443"
+	tcpPort   = "1884"
+	mqttPort  = "1883" // -transport=mqtt: Rx의 내장 브로커
+)
+
+type sData struct {
+	Id         int64             `json:"id"` // POST 시 0(생략)이면 서버가 할당
+	Name       string            `json:"name"`
+	Address    string            `json:"address"`
+	Sex        string            `json:"sex"`                  // "Male", "Female", "Other" (Protobuf에서는 enum)
+	Version    int64             `json:"version,omitempty"`    // PUT/PATCH/DELETE 시 기대하는 레코드 버전 (0이면 확인 안 함)
+	CreatedAt  *time.Time        `json:"created_at,omitempty"` // Tx가 관리 (요청에 보내도 무시)
+	UpdatedAt  *time.Time        `json:"updated_at,omitempty"` // Tx가 관리 (요청에 보내도 무시)
+	Attributes map[string]string `json:"attributes,omitempty"` // 추가 속성
+	TTL        string            `json:"ttl,omitempty"`        // 요청 전용: 지금부터 이 시간 후 만료 (예: "30s", "10m")
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"` // 만료 시각 (TTL 대신 직접 지정 가능)
+	DeletedAt  *time.Time        `json:"deleted_at,omitempty"` // 삭제 시각 (이력 조회에서만 보임)
+}
+
+// sex 문자열 <-> enum 변환 (HTTP JSON은 계속 문자열 사용)
+var sexNames = map[pt.Sex]string{
+	pt.Sex_SEX_MALE:   "Male",
+	pt.Sex_SEX_FEMALE: "Female",
+	pt.Sex_SEX_OTHER:  "Other",
+}
+
+// 예전 이름 ("Male")과 표준 형식의 enum 이름 ("SEX_MALE") 모두 받음
+func sexFromString(s string) pt.Sex {
+	for sex, name := range sexNames {
+		if name == s || sex.String() == s {
+			return sex
+		}
+	}
+	return pt.Sex_SEX_UNSPECIFIED
+}
+
+// sex 문자열 -> enum과 예전 문자열 필드에 넣을 값 (이전 버전 Rx가 읽는 legacy_sex는 항상 "Male" 같은 이름)
+func sexFields(s string) (pt.Sex, string) {
+	sex := sexFromString(s)
+	if name, ok := sexNames[sex]; ok {
+		return sex, name
+	}
+	return sex, s
+}
+
+// enum이 비어 있으면 (이전 버전 Tx가 보냈거나 알 수 없는 값) 예전 문자열 필드 사용
+func sexString(d *pt.Data) string {
+	if name, ok := sexNames[d.Sex]; ok {
+		return name
+	}
+	return d.LegacySex
+}
+
+// JSON 구조체 -> Protobuf (버전과 타임스탬프는 Tx가 따로 채움)
+func toProtoData(data sData) *pt.Data {
+	sex, legacySex := sexFields(data.Sex)
+	return &pt.Data{
+		Id:         data.Id,
+		Name:       data.Name,
+		Address:    data.Address,
+		Sex:        sex,
+		LegacySex:  legacySex, // 이전 버전 Rx도 읽을 수 있도록 함께 채움
+		Attributes: data.Attributes,
+	}
+}
+
+// Protobuf -> JSON 구조체 (GET 응답)
+func toJSONData(d *pt.Data) sData {
+	data := sData{
+		Id:         d.Id,
+		Name:       d.Name,
+		Address:    d.Address,
+		Sex:        sexString(d),
+		Version:    d.Version,
+		Attributes: d.Attributes,
+	}
+	if d.CreatedAt != nil {
+		createdAt := d.CreatedAt.AsTime()
+		data.CreatedAt = &createdAt
+	}
+	if d.UpdatedAt != nil {
+		updatedAt := d.UpdatedAt.AsTime()
+		data.UpdatedAt = &updatedAt
+	}
+	if d.ExpiresAt != nil {
+		expiresAt := d.ExpiresAt.AsTime()
+		data.ExpiresAt = &expiresAt
+	}
+	if d.DeletedAt != nil {
+		deletedAt := d.DeletedAt.AsTime()
+		data.DeletedAt = &deletedAt
+	}
+	return data
+}
+
+// 요청의 ttl 또는 expires_at으로 만료 시각 계산 (둘 다 없으면 nil -> 만료 없음)
+func expiryFor(data sData, now time.Time) (*timestamppb.Timestamp, *fieldError) {
+	switch {
+	case data.TTL != "" && data.ExpiresAt != nil:
+		return nil, &fieldError{"ttl", "exclusive", "ttl and expires_at cannot both be set"}
+	case data.TTL != "":
+		ttl, err := time.ParseDuration(data.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, &fieldError{"ttl", "format", "must be a positive duration such as 30s or 10m"}
+		}
+		return timestamppb.New(now.Add(ttl)), nil
+	case data.ExpiresAt != nil:
+		if !data.ExpiresAt.After(now) {
+			return nil, &fieldError{"expires_at", "range", "must be in the future"}
+		}
+		return timestamppb.New(*data.ExpiresAt), nil
+	}
+	return nil, nil
+}
+
+func toJSONList(dataList []*pt.Data) []sData {
+	jsonList := make([]sData, len(dataList))
+	for i, d := range dataList {
+		jsonList[i] = toJSONData(d)
+	}
+	return jsonList
+}
+
+// 표준 JSON 형식 -> Tx와 Rx의 모든 JSON 응답, 로그, 요청 본문이 이 옵션의 protojson을 사용
+// (필드 이름은 .proto 그대로, 빈 필드는 생략 -> 삭제되지 않은 레코드에 deleted_at이 보이지 않음)
+var canonicalJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+// protojson은 일부러 공백을 무작위로 넣음 -> 같은 데이터가 항상 같은 바이트가 되도록 (내보낸 파일 비교 등) 공백 제거
+// legacy_sex는 이전 버전 Rx와의 와이어 호환용이라 표준 형식에는 넣지 않음
+func marshalCanonical(m proto.Message) ([]byte, error) {
+	if d, ok := m.(*pt.Data); ok {
+		m = withoutLegacySex(d)
+	}
+	raw, err := canonicalJSON.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 예전 문자열 sex 필드를 비운 복사본 (비어 있으면 그대로)
+func withoutLegacySex(d *pt.Data) *pt.Data {
+	if d.LegacySex == "" {
+		return d
+	}
+	clean := proto.Clone(d).(*pt.Data)
+	clean.LegacySex = ""
+	return clean
+}
+
+// -legacy_json: 예전 형식 (sData, sex는 "Male" 같은 문자열, 빈 필드 생략)으로 응답하고 요청도 그 형식으로 읽음
+var legacyJSON bool
+
+func marshalJSONRecord(d *pt.Data) ([]byte, error) {
+	if legacyJSON {
+		return json.Marshal(toJSONData(d))
+	}
+	return marshalCanonical(d)
+}
+
+// 레코드 목록은 JSON 배열 (각 레코드는 marshalJSONRecord 형식)
+func marshalJSONList(dataList []*pt.Data) ([]byte, error) {
+	if legacyJSON {
+		return json.Marshal(toJSONList(dataList))
+	}
+	buf := []byte{'['}
+	for i, d := range dataList {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		record, err := marshalCanonical(d)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, record...)
+	}
+	return append(buf, ']'), nil
+}
+
+// 요청 본문의 레코드 하나 -> sData (표준 형식은 Protobuf로 읽은 뒤 변환)
+// 표준 형식에 없는 요청 전용 ttl과 예전 sex 값 ("Male" 등)도 받음
+func unmarshalJSONRecord(raw []byte) (sData, error) {
+	var data sData
+	if legacyJSON {
+		err := json.Unmarshal(raw, &data)
+		return data, err
+	}
+	d := &pt.Data{}
+	if err := protojson.Unmarshal(raw, d); err != nil {
+		// 대부분의 요청은 위에서 끝남 -> ttl이나 예전 sex 값이 있을 때만 고쳐서 다시 읽음
+		fixed, ttl, ok := canonicalRequest(raw)
+		if !ok {
+			return data, err
+		}
+		d = &pt.Data{}
+		if err := protojson.Unmarshal(fixed, d); err != nil {
+			return data, err
+		}
+		data.TTL = ttl
+	}
+	upgradeData(d)
+	ttl := data.TTL
+	data = toJSONData(d)
+	data.TTL = ttl
+	return data, nil
+}
+
+// 표준 형식 요청에서 ttl을 떼어 내고 예전 sex 값을 enum 이름으로 바꾼 본문 (바꿀 것이 없으면 false)
+func canonicalRequest(raw []byte) ([]byte, string, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, "", false
+	}
+	var ttl string
+	changed := false
+	if value, ok := fields["ttl"]; ok {
+		if err := json.Unmarshal(value, &ttl); err != nil {
+			return nil, "", false
+		}
+		delete(fields, "ttl")
+		changed = true
+	}
+	var name string
+	if value, ok := fields["sex"]; ok && json.Unmarshal(value, &name) == nil {
+		if sex := sexFromString(name); sex != pt.Sex_SEX_UNSPECIFIED && sex.String() != name {
+			fields["sex"], _ = json.Marshal(sex.String())
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, "", false
+	}
+	fixed, err := json.Marshal(fields)
+	return fixed, ttl, err == nil
+}
+
+// 이전 버전 Tx가 보낸 데이터는 sex enum이 비어 있음 -> 문자열 필드로 채움
+func upgradeData(d *pt.Data) {
+	if d.Sex == pt.Sex_SEX_UNSPECIFIED && d.LegacySex != "" {
+		d.Sex = sexFromString(d.LegacySex)
+	}
+}
+
+var tombstoneRetention time.Duration // 삭제된 레코드(툼스톤)를 보관하는 기간
+var historyLimit int                 // 레코드마다 보관하는 이력 개수
+var feedLimit int                    // 컬렉션마다 변경 피드에 보관하는 이벤트 개수
+
+const defaultCollection = "default" // 컬렉션을 지정하지 않은 기존 경로 (/, /transaction, ...)가 사용
+
+// 클라이언트가 지정할 수 있는 가장 큰 ID -> 그 위로는 서버가 할당하는 ID를 위해 남겨 둠 (lastId가 넘치지 않도록)
+const maxClientId = math.MaxInt64 / 2
+
+// 컬렉션 이름 -> URL 경로에 그대로 들어가므로 문자 제한
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Tx의 컬렉션 하나 -> 데이터, 버전, 이력, Rx 전송 순서를 컬렉션마다 따로 관리
+type txCollection struct {
+	name         string
+	data         []*pt.Data
+	index        map[int64]int            // ID -> data에서의 위치 (data를 바꾸는 쪽에서 함께 갱신)
+	version      uint64                   // 컬렉션 버전, 변경이 커밋될 때마다 1 증가
+	lastId       int64                    // 지금까지 사용된 가장 큰 ID -> 서버가 할당하는 ID는 여기서부터 증가 (삭제되어도 재사용 X)
+	history      map[int64][]historyEntry // ID -> 오래된 순서의 이력
+	historyFloor uint64                   // 이 버전보다 이전 시점은 일부 레코드의 이력이 잘려서 정확히 복원할 수 없음
+	mu           sync.RWMutex             // 여러 요청이 동시에 data를 수정하지 않도록
+	replication  sync.Mutex               // 커밋한 순서대로 Rx에 전송되도록
+	feed         changeFeed               // 커밋마다 바뀐 레코드 (GET /changes)
+	resync       bool                     // Rx 전송이 실패함 -> 다음 커밋은 트랜잭션 대신 전체 데이터로 전송 (replication으로 보호)
+	writers      int                      // 처리 중인 쓰기 요청 수 (collectionsMutex로 보호) -> 커밋 없이 모두 끝나면 목록에서 제거
+
+	schema  *dynamicSchema       // 설정되면 data 대신 records 사용 (컬렉션을 만들 때만 지정, 이후 변경 X)
+	records []*dynamicpb.Message // 동적 스키마 레코드
+}
+
+// Rx의 컬렉션 하나
+type rxCollection struct {
+	data    []*pt.Data
+	index   map[int64]int // ID -> data에서의 위치
+	version uint64        // Rx에 마지막으로 반영된 컬렉션 버전
+	mu      sync.RWMutex  // 트랜잭션이 반쯤 적용된 data가 GET에 노출되지 않도록
+	feed    changeFeed    // 반영한 패키지마다 바뀐 레코드 (GET /changes)
+
+	schema  *dynamicSchema // Tx가 패키지와 함께 보낸 스키마
+	records []*dynamicpb.Message
+}
+
+var txCollections = map[string]*txCollection{defaultCollection: newTxCollection(defaultCollection)}
+var rxCollections = map[string]*rxCollection{defaultCollection: newRxCollection(defaultCollection)}
+var collectionsMutex sync.Mutex // 컬렉션 목록 보호 (각 컬렉션의 데이터는 컬렉션의 mu로 보호)
+
+func newTxCollection(name string) *txCollection {
+	return &txCollection{name: name, index: make(map[int64]int), history: make(map[int64][]historyEntry), feed: changeFeed{name: name}}
+}
+
+func newRxCollection(name string) *rxCollection {
+	return &rxCollection{index: make(map[int64]int), feed: changeFeed{name: name}}
+}
+
+// ID -> 위치 (data 전체를 새로 받았을 때만 다시 만듦)
+func indexData(dataList []*pt.Data) map[int64]int {
+	index := make(map[int64]int, len(dataList))
+	for i, d := range dataList {
+		index[d.Id] = i
+	}
+	return index
+}
+
+// 요청 경로의 컬렉션 이름 (/collections/{name}/...), 기존 경로는 기본 컬렉션
+func collectionName(r *http.Request) (string, error) {
+	name := r.PathValue("name")
+	if name == "" {
+		return defaultCollection, nil
+	}
+	if !collectionNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid collection name %q (letters, digits, _ and - only, up to 64)", name)
+	}
+	return name, nil
+}
+
+// create가 true면 없는 컬렉션을 새로 만듦 (쓰기 요청, 끝나면 releaseTxCollection), false면 없을 때 nil
+// -> 새로 만든 컬렉션은 첫 쓰기가 커밋될 때까지 없는 것으로 취급 (실패한 요청이 빈 컬렉션을 남기지 않도록)
+func txCollectionFor(name string, create bool) *txCollection {
+	collectionsMutex.Lock()
+	defer collectionsMutex.Unlock()
+	c, ok := txCollections[name]
+	if !ok && create {
+		c = newTxCollection(name)
+		txCollections[name] = c
+	}
+	if c != nil && !create && !c.exists() {
+		return nil
+	}
+	if create {
+		c.writers++
+	}
+	return c
+}
+
+// 쓰기 요청이 끝나면 호출 -> 새로 만든 컬렉션이 끝내 커밋되지 않았으면 (실패, 412 등) 목록에서 제거
+// (같은 컬렉션에 쓰는 다른 요청이 남아 있으면 마지막 요청이 끝날 때 판단)
+func releaseTxCollection(c *txCollection) {
+	collectionsMutex.Lock()
+	defer collectionsMutex.Unlock()
+	c.writers--
+	if c.writers == 0 && !c.exists() && txCollections[c.name] == c {
+		delete(txCollections, c.name)
+	}
+}
+
+// 기본 컬렉션, 동적 스키마 컬렉션, 한 번이라도 커밋된 컬렉션만 목록과 조회에 보임
+func (c *txCollection) exists() bool {
+	if c.name == defaultCollection || c.schema != nil {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version > 0
+}
+
+func rxCollectionFor(name string, create bool) *rxCollection {
+	collectionsMutex.Lock()
+	defer collectionsMutex.Unlock()
+	c, ok := rxCollections[name]
+	if !ok && create {
+		c = newRxCollection(name)
+		rxCollections[name] = c
+		log.Printf("Created collection %q", name)
+	}
+	return c
+}
+
+// 이름 순으로 정렬한 Tx 컬렉션 목록
+func txCollectionList() []*txCollection {
+	collectionsMutex.Lock()
+	list := make([]*txCollection, 0, len(txCollections))
+	for _, c := range txCollections {
+		if c.exists() {
+			list = append(list, c)
+		}
+	}
+	collectionsMutex.Unlock()
+	slices.SortFunc(list, func(a, b *txCollection) int { return cmp.Compare(a.name, b.name) })
+	return list
+}
+
+// 요청의 컬렉션을 찾아 핸들러에 넘김 -> GET, DELETE는 없는 컬렉션이면 404, 쓰기 요청은 컬렉션을 만듦 (커밋되어야 보임)
+func withTxCollection(handler func(http.ResponseWriter, *http.Request, *txCollection)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, err := collectionName(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		create := r.Method != http.MethodGet && r.Method != http.MethodDelete
+		c := txCollectionFor(name, create)
+		if c == nil {
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q not found", name))
+			return
+		}
+		if create {
+			defer releaseTxCollection(c)
+		}
+		handler(w, r, c)
+	}
+}
+
+// 동적 스키마 컬렉션에서는 data 전용 기능(트랜잭션, 복구, 이력)을 쓸 수 없음
+func fixedSchemaOnly(handler func(http.ResponseWriter, *http.Request, *txCollection)) func(http.ResponseWriter, *http.Request, *txCollection) {
+	return func(w http.ResponseWriter, r *http.Request, c *txCollection) {
+		if c.schema != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("collection %q has a dynamic schema (%s); only GET and POST on /data are supported", c.name, c.schema.name()))
+			return
+		}
+		handler(w, r, c)
+	}
+}
+
+// 컬렉션 목록 응답 항목
+type collectionInfo struct {
+	Name    string `json:"name"`
+	Version uint64 `json:"version"`
+	Count   int    `json:"count"`
+	Schema  string `json:"schema,omitempty"` // 동적 스키마 컬렉션의 메시지 이름
+}
+
+// GET /collections -> 컬렉션별 버전과 데이터 개수
+func handleTxCollections(w http.ResponseWriter, r *http.Request) {
+	var infos []collectionInfo
+	for _, c := range txCollectionList() {
+		c.mu.RLock()
+		infos = append(infos, collectionInfo{Name: c.name, Version: c.version, Count: len(liveData(c.data)) + len(c.records), Schema: c.schema.name()})
+		c.mu.RUnlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+func handleRxCollections(w http.ResponseWriter, r *http.Request) {
+	collectionsMutex.Lock()
+	infos := make([]collectionInfo, 0, len(rxCollections))
+	for name, c := range rxCollections {
+		c.mu.RLock()
+		infos = append(infos, collectionInfo{Name: name, Version: c.version, Count: len(liveData(c.data)) + len(c.records), Schema: c.schema.name()})
+		c.mu.RUnlock()
+	}
+	collectionsMutex.Unlock()
+	slices.SortFunc(infos, func(a, b collectionInfo) int { return cmp.Compare(a.Name, b.Name) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+func main() {
+	mode := flag.String("mode", "tx", "tx(transport), rx(receive) or broker(minimal MQTT broker for testing -mqtt_broker)")
+	protocol := flag.String("pro", "http", "http or https")
+	expireEvery := flag.Duration("expire_every", time.Second, "How often Tx removes expired data (TTL) and old tombstones")
+	flag.DurationVar(&tombstoneRetention, "tombstone_retention", time.Hour, "How long Tx keeps deleted data before purging it")
+	flag.Func("schema", "Create a collection with a dynamic schema: name=descriptor_set.pb:package.Message (repeatable, for tx)", addSchemaFlag)
+	flag.BoolVar(&legacyJSON, "legacy_json", false, "Serve and accept the old JSON shape (string sex, empty fields omitted) instead of canonical protojson")
+	flag.IntVar(&historyLimit, "history_limit", 20, "How many versions of each record Tx keeps for /history and /snapshot")
+	flag.StringVar(&deadLetterFile, "webhook_dead_letters", "", "File to append webhook events that could not be delivered (NDJSON)")
+	flag.StringVar(&webhookToken, "webhook_token", "", "Token clients must send as \"Authorization: Bearer <token>\" to manage /webhooks (webhooks are disabled without it)")
+	flag.IntVar(&feedLimit, "feed_limit", 10000, "How many change events each collection keeps for resuming /changes")
+	flag.StringVar(&mqttBrokerAddr, "mqtt_broker", "", "Publish every change to this MQTT broker (host:port)")
+	flag.StringVar(&mqttClientId, "mqtt_client_id", "", "MQTT client ID (default: generated)")
+	flag.IntVar(&mqttQoS, "mqtt_qos", 1, "MQTT QoS for published changes (0 or 1)")
+	flag.BoolVar(&mqttRetain, "mqtt_retain", true, "Publish changes as retained messages so each topic keeps the record's last value")
+	flag.StringVar(&mqttFormat, "mqtt_format", "json", "MQTT payload: json (change event) or protobuf (record)")
+	flag.StringVar(&mqttTopicPrefix, "mqtt_topic_prefix", "prototest", "MQTT topic prefix ({prefix}/data/{id})")
+	mqttListen := flag.String("mqtt_listen", ":"+mqttPort, "Address the minimal MQTT broker listens on (for broker)")
+	flag.StringVar(&redisListen, "redis_listen", "", "Serve RxData read-only over the Redis protocol (RESP2) on this address, e.g. :6379 (for rx)")
+	flag.StringVar(&rxTransport, "transport", "tcp", "How Tx sends DataPackages to Rx: tcp (length-prefixed frames) or mqtt (Rx runs an embedded MQTT broker); set the same on tx and rx")
+	flag.StringVar(&rxPassword, "transport_password", "", "Required with -transport=mqtt: the password Tx connects with and the Rx broker requires before accepting DataPackages; set the same on tx and rx")
+	flag.Parse()
+
+	if rxTransport != "tcp" && rxTransport != "mqtt" {
+		fmt.Println("-transport는 tcp와 mqtt 중 입력 바람")
+		os.Exit(1)
+	}
+	if rxTransport == "mqtt" && rxPassword == "" && (*mode == "tx" || *mode == "rx") {
+		log.Fatalf("-transport=mqtt requires -transport_password (otherwise any client can connect as %s)", rxPublisherId)
+	}
+	if *expireEvery <= 0 {
+		log.Fatalf("-expire_every must be positive")
+	}
+	if historyLimit < 1 {
+		log.Fatalf("-history_limit must be at least 1")
+	}
+	if feedLimit < 1 {
+		log.Fatalf("-feed_limit must be at least 1")
+	}
+	if mqttBrokerAddr != "" && *mode != "broker" {
+		if *mode == "rx" && rxTransport == "mqtt" {
+			log.Fatalf("-mqtt_broker cannot be used with -transport=mqtt on rx (changes are published to its embedded broker)")
+		}
+		if mqttClientId == "" {
+			mqttClientId = "prototest-" + newRequestId()
+		}
+		client := newMQTTClient(mqttBrokerAddr, mqttClientId)
+		go client.run()
+		startMQTTBridge(client.publish, "MQTT broker "+mqttBrokerAddr)
+	}
+	if *mode == "tx" {
+		go expireTxDataEvery(*expireEvery)
+		startTxServer(*protocol)
+	} else if *mode == "rx" {
+		startRxServer(*protocol)
+	} else if *mode == "broker" {
+		newMQTTBroker().listen(*mqttListen)
+	} else {
+		fmt.Println("tx, rx, broker 중 입력 바람")
+		os.Exit(1)
+	}
+}
+
+func startTxServer(protocol string) {
+	// 기본 컬렉션은 경로 그대로, 이름을 붙인 컬렉션은 /collections/{name} 아래
+	// -> 팀마다 다른 컬렉션을 쓰면 서로의 데이터를 덮어쓰지 않음
+	for _, prefix := range []string{"", "/collections/{name}"} {
+		http.HandleFunc("GET "+prefix+"/data", withTxCollection(handleTxRequest))
+		http.HandleFunc("POST "+prefix+"/data", withTxCollection(handleTxCreateRecord))
+		http.HandleFunc("GET "+prefix+"/data/{id}", withTxCollection(fixedSchemaOnly(handleTxGetRecord)))
+		http.HandleFunc("PUT "+prefix+"/data/{id}", withTxCollection(fixedSchemaOnly(handleTxUpdateRecord)))
+		http.HandleFunc("PATCH "+prefix+"/data/{id}", withTxCollection(fixedSchemaOnly(handleTxUpdateRecord)))
+		http.HandleFunc("DELETE "+prefix+"/data/{id}", withTxCollection(fixedSchemaOnly(handleTxDeleteRecord)))
+		// 여러 레코드를 JSON 배열로 한 번에 처리 (기존 방식)
+		for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+			http.HandleFunc(method+" "+prefix+"/batch", withTxCollection(handleTxRequest))
+		}
+		http.HandleFunc("POST "+prefix+"/import", withTxCollection(fixedSchemaOnly(handleTxImport)))
+		http.HandleFunc("POST "+prefix+"/transaction", withTxCollection(fixedSchemaOnly(handleTxTransaction)))
+		http.HandleFunc("POST "+prefix+"/undelete", withTxCollection(fixedSchemaOnly(handleTxUndelete)))
+		http.HandleFunc("GET "+prefix+"/history", withTxCollection(fixedSchemaOnly(handleTxHistory)))
+		http.HandleFunc("GET "+prefix+"/snapshot", withTxCollection(fixedSchemaOnly(handleTxSnapshot)))
+		http.HandleFunc("GET "+prefix+"/export", withTxCollection(fixedSchemaOnly(handleTxExport)))
+		http.HandleFunc("GET "+prefix+"/changes", withTxCollection(fixedSchemaOnly(handleTxChanges)))
+	}
+	// 이전 클라이언트 호환: 루트 경로는 기본 컬렉션의 배치 경로와 같음
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		http.HandleFunc(method+" /{$}", withTxCollection(handleTxRequest))
+	}
+	http.HandleFunc("GET /collections", handleTxCollections)
+	webhookFeed = func(name string) *changeFeed {
+		if c := txCollectionFor(name, false); c != nil && c.schema == nil {
+			return &c.feed
+		}
+		return nil
+	}
+	registerWebhookRoutes()
+	http.HandleFunc("GET /collections/{name}/schema", handleTxSchema)
+	http.HandleFunc("PUT /collections/{name}/schema", handleTxSchema)
+
+	if rxTransport == "mqtt" {
+		startRxPublisher()
+	}
+
+	if protocol == "http" {
+		log.Printf("Starting HTTP Tx server on port %s", httpPort)
+		if err := http.ListenAndServe(":"+httpPort, withRequestId(http.DefaultServeMux)); err != nil { // HTTP 서버 실행
+			log.Fatalf("Failed to start HTTP Tx server: %v", err)
+		}
+	} else if protocol == "https" {
+		log.Printf("Starting HTTPS Tx server on port %s", httpsPort)
+		if err := http.ListenAndServeTLS(":"+httpsPort, "cert.pem", "key.pem", withRequestId(http.DefaultServeMux)); err != nil {
+			log.Fatalf("Failed to start HTTPS Tx server: %v", err)
+		}
+	} else {
+		log.Print("http와 https 중 입력 바람")
+		os.Exit(1)
+	}
+}
+
+func startRxServer(protocol string) {
+	// Rx는 조회만 가능 -> 다른 메서드는 405 (Allow: GET, HEAD)
+	http.HandleFunc("GET /{$}", handleRxRequest)
+	for _, prefix := range []string{"", "/collections/{name}"} {
+		http.HandleFunc("GET "+prefix+"/data", handleRxRequest)
+		http.HandleFunc("GET "+prefix+"/data/{id}", handleRxGetRecord)
+		http.HandleFunc("GET "+prefix+"/export", handleRxExport)
+		http.HandleFunc("GET "+prefix+"/changes", handleRxChanges)
+	}
+	http.HandleFunc("GET /collections", handleRxCollections)
+	webhookFeed = func(name string) *changeFeed {
+		if c := rxCollectionFor(name, false); c != nil {
+			return &c.feed
+		}
+		return nil
+	}
+	registerWebhookRoutes()
+	if redisListen != "" {
+		go startRxRedisServer(redisListen)
+	}
+
+	if protocol == "http" {
+		log.Printf("Starting HTTP Rx server on port %s", httpPort)
+		go startRxReceiver() // tcp 소켓 (또는 내장 MQTT 브로커)으로부터 데이터 수신하도록
+		if err := http.ListenAndServe(":"+httpPort, withRequestId(http.DefaultServeMux)); err != nil {
+			log.Fatalf("Failed to start HTTP Rx server: %v", err)
+		}
+	} else if protocol == "https" {
+		log.Printf("Starting HTTPS Rx server on port %s", httpsPort)
+		go startRxReceiver()
+		if err := http.ListenAndServeTLS(":"+httpsPort, "cert.pem", "key.pem", withRequestId(http.DefaultServeMux)); err != nil {
+			log.Fatalf("Failed to start HTTPS Rx server: %v", err)
+		}
+	} else {
+		log.Print("http와 https 중 입력 바람")
+		os.Exit(1)
+	}
+}
+
+func handleTxRequest(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	if c.schema != nil {
+		handleTxDynamicRequest(w, r, c)
+		return
+	}
+	if r.Method == http.MethodGet {
+		query, err := parseListQuery(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		format, err := responseFormat(r)
+		if err != nil {
+			writeJSONError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		start := time.Now()
+		c.mu.RLock()
+		version := c.version
+		if notModified(r, datasetETag(version)) {
+			c.mu.RUnlock()
+			w.Header().Set("ETag", datasetETag(version))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		page := query.apply(liveData(c.data)) // 툼스톤은 숨김
+		c.mu.RUnlock()
+		responseData, err := encodeList(format, page.data, version)
+		if err != nil {
+			writeInternalError(w, fmt.Errorf("failed to encode Tx data: %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", format)
+		w.Header().Set("Vary", "Accept")
+		w.Header().Set("ETag", datasetETag(version)) // 클라이언트는 이 값을 If-Match로 보내 안전하게 수정
+		page.setHeaders(w, r)
+		w.Write(responseData)
+		end := time.Since(start)
+		//log.Println("Tx - Processed GET request")
+		fmt.Printf("-- Tx_Time elapsed for GET request: %d ms.\n", end.Milliseconds())
+	} else if r.Method == http.MethodPost {
+		processTxData(w, r, c, "POST")
+		//log.Println("Tx - Processed POST request")
+	} else if r.Method == http.MethodPut {
+		processTxData(w, r, c, "PUT")
+		//log.Println("Tx - Processed PUT request")
+	} else if r.Method == http.MethodPatch {
+		processTxData(w, r, c, "PATCH")
+	} else if r.Method == http.MethodDelete {
+		processTxData(w, r, c, "DELETE")
+		//log.Println("Tx - Processed DELETE request")
+	} else {
+		methodNotAllowed(w, "GET", "POST", "PUT", "PATCH", "DELETE")
+	}
+}
+
+func handleRxRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		name, err := collectionName(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		c := rxCollectionFor(name, false)
+		if c == nil {
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q not found", name))
+			return
+		}
+		if !waitForVersion(w, r, c) {
+			return
+		}
+		c.mu.RLock()
+		dynamic := c.schema != nil
+		c.mu.RUnlock()
+		if dynamic {
+			handleRxDynamicRequest(w, r, c)
+			return
+		}
+		query, err := parseListQuery(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		format, err := responseFormat(r)
+		if err != nil {
+			writeJSONError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		start := time.Now()
+		c.mu.RLock()
+		version := c.version
+		if notModified(r, datasetETag(version)) {
+			c.mu.RUnlock()
+			w.Header().Set("ETag", datasetETag(version))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		page := query.apply(liveData(c.data))
+		c.mu.RUnlock()
+		responseData, err := encodeList(format, page.data, version)
+		if err != nil {
+			writeInternalError(w, fmt.Errorf("failed to encode Rx data: %w", err))
+			return // 에러가 발생하면 함수 종료
+		}
+		w.Header().Set("Content-Type", format)
+		w.Header().Set("Vary", "Accept")
+		w.Header().Set("ETag", datasetETag(version))
+		page.setHeaders(w, r)
+		w.Write(responseData)
+		end := time.Since(start)
+		//log.Println("Rx - Processed GET request")
+		fmt.Printf("-- Rx_Time elapsed for GET request: %d ms.\n", end.Milliseconds())
+	} else {
+		methodNotAllowed(w, "GET")
+	}
+}
+
+// 데이터셋 버전을 ETag 형식으로 ("v12")
+func datasetETag(version uint64) string {
+	return fmt.Sprintf("\"v%d\"", version)
+}
+
+// 레코드 버전을 ETag 형식으로 ("r3") -> 레코드 하나의 경로 (/data/{id})에서 사용, 없는 레코드는 빈 문자열
+// (삭제 후 같은 ID로 다시 만들어도 레코드 버전은 이어서 증가하므로 겹치지 않음)
+func recordETag(d *pt.Data) string {
+	if d == nil {
+		return ""
+	}
+	return fmt.Sprintf("\"r%d\"", d.Version)
+}
+
+// ETag 목록 헤더(If-Match, If-None-Match)에 현재 ETag가 포함되는지 (*는 대상이 있으면 일치)
+// weak가 false면 강한 비교 (If-Match) -> W/ 태그는 일치하지 않음
+func etagListMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// If-Match가 없거나 현재 ETag와 맞으면 true -> 다르면 그 사이에 다른 클라이언트가 수정한 것 (412)
+func ifMatchOK(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	return header == "" || etagListMatches(header, etag, false)
+}
+
+// If-None-Match가 현재 ETag와 같으면 GET 본문 대신 304
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	return header != "" && etagListMatches(header, etag, true)
+}
+
+// 필드별 검증 규칙 (Tx가 받은 레코드와 Rx가 적용할 패키지 모두 같은 규칙으로 검증)
+type fieldRule struct {
+	Field    string
+	Value    func(d *pt.Data) string // 검증할 값 (숫자 필드는 문자열로 변환)
+	Required bool                    // 비어 있으면 안 됨
+	MinLen   int
+	MaxLen   int            // 0이면 제한 없음
+	Pattern  *regexp.Regexp // 값이 있을 때만 확인
+	Enum     []string       // 값이 있을 때만 확인
+	Unique   bool           // 같은 요청(패키지) 안에서 값이 겹치면 안 됨
+}
+
+var dataRules = []fieldRule{
+	{
+		Field: "id",
+		Value: func(d *pt.Data) string {
+			if d.Id == 0 {
+				return "" // 0은 값이 없는 것으로 취급
+			}
+			return strconv.FormatInt(d.Id, 10)
+		},
+		Required: true,
+		Pattern:  regexp.MustCompile(`^[1-9][0-9]*$`), // 양의 정수
+		Unique:   true,
+	},
+	{
+		Field:    "name",
+		Value:    func(d *pt.Data) string { return d.Name },
+		Required: true,
+		MaxLen:   100,
+		Pattern:  regexp.MustCompile(`^\S(.*\S)?$`), // 앞뒤 공백 X
+	},
+	{
+		Field:  "address",
+		Value:  func(d *pt.Data) string { return d.Address },
+		MaxLen: 200,
+	},
+	{
+		Field: "sex",
+		Value: sexString, // 알 수 있는 값은 항상 예전 이름 ("Male")으로 바뀜 -> 그 밖의 값만 거부됨
+		Enum:  []string{"Male", "Female", "Other", "SEX_MALE", "SEX_FEMALE", "SEX_OTHER"},
+	},
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"` // required, length, pattern, enum, unique
+	Message string `json:"message"`
+}
+
+func joinFieldErrors(errs []fieldError) string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// 레코드 하나에 대해 Unique를 제외한 규칙 확인
+func validateRecord(d *pt.Data) []fieldError {
+	var errs []fieldError
+	for _, rule := range dataRules {
+		value := rule.Value(d)
+		length := utf8.RuneCountInString(value)
+		switch {
+		case value == "":
+			if rule.Required {
+				errs = append(errs, fieldError{rule.Field, "required", "is required"})
+			}
+		case length < rule.MinLen || (rule.MaxLen > 0 && length > rule.MaxLen):
+			errs = append(errs, fieldError{rule.Field, "length", fmt.Sprintf("length must be between %d and %d", rule.MinLen, rule.MaxLen)})
+		case rule.Pattern != nil && !rule.Pattern.MatchString(value):
+			errs = append(errs, fieldError{rule.Field, "pattern", fmt.Sprintf("must match %s", rule.Pattern)})
+		case len(rule.Enum) > 0 && !slices.Contains(rule.Enum, value):
+			errs = append(errs, fieldError{rule.Field, "enum", fmt.Sprintf("must be one of %s", strings.Join(rule.Enum, ", "))})
+		}
+	}
+	return errs
+}
+
+// Unique 규칙 확인: 요청(패키지)마다 새로 만들어 사용
+type uniqueChecker struct {
+	seen map[string]map[string]bool // 필드 -> 이미 나온 값
+}
+
+func newUniqueChecker() *uniqueChecker {
+	return &uniqueChecker{seen: make(map[string]map[string]bool)}
+}
+
+func (u *uniqueChecker) check(d *pt.Data) []fieldError {
+	var errs []fieldError
+	for _, rule := range dataRules {
+		if !rule.Unique {
+			continue
+		}
+		value := rule.Value(d)
+		if u.seen[rule.Field] == nil {
+			u.seen[rule.Field] = make(map[string]bool)
+		}
+		if u.seen[rule.Field][value] {
+			errs = append(errs, fieldError{rule.Field, "unique", fmt.Sprintf("value %q appears more than once", value)})
+		}
+		u.seen[rule.Field][value] = true
+	}
+	return errs
+}
+
+// Rx가 받은 전체 데이터 목록 검증 -> 잘못된 레코드마다 한 줄씩
+func validatePackage(dataList []*pt.Data) []string {
+	var problems []string
+	unique := newUniqueChecker()
+	for _, d := range dataList {
+		if errs := append(validateRecord(d), unique.check(d)...); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("id %d: %s", d.Id, joinFieldErrors(errs)))
+		}
+	}
+	return problems
+}
+
+// 쓰기 방식: 요청마다 ?mode= 쿼리로 선택 (생략하면 메서드별 기본값 사용)
+const (
+	writeReplace = "replace" // 기존 TxData를 모두 지우고 받은 데이터로 교체 (POST 기본값)
+	writeAppend  = "append"  // 기존 데이터 뒤에 추가, 이미 존재하는 ID는 거부
+	writeUpsert  = "upsert"  // 존재하면 갱신, 없으면 추가
+	writeUpdate  = "update"  // 존재하는 ID만 갱신, 없으면 not_found (PUT 기본값)
+	writeMerge   = "merge"   // 존재하는 ID에 대해 비어있지 않은 필드만 덮어쓰기 (PATCH 기본값)
+)
+
+// 같은 요청 안에서 ID가 중복될 때의 처리: ?dup= 쿼리로 선택
+const (
+	dupFirst  = "first"  // 첫 번째 항목만 반영, 나머지는 duplicate (기본값)
+	dupLast   = "last"   // 마지막 항목만 반영, 나머지는 duplicate
+	dupReject = "reject" // 중복된 ID의 항목은 모두 반영하지 않음
+)
+
+// 항목별 처리 결과
+const (
+	outcomeCreated   = "created"
+	outcomeUpdated   = "updated"
+	outcomeDeleted   = "deleted"
+	outcomeRestored  = "restored"
+	outcomeNotFound  = "not_found"
+	outcomeExists    = "exists"    // append 시 이미 존재하는 ID
+	outcomeDuplicate = "duplicate" // 같은 요청 안에서 중복된 ID
+	outcomeInvalid   = "invalid"   // 항목 자체가 잘못됨 (ID가 0 이하 등)
+	outcomeConflict  = "conflict"  // 기대한 버전과 현재 레코드 버전이 다름
+)
+
+type itemResult struct {
+	Id      int64        `json:"id"` // 서버가 할당한 경우 할당된 ID
+	Outcome string       `json:"outcome"`
+	Error   string       `json:"error,omitempty"`  // 실패한 항목만 사유 기록
+	Fields  []fieldError `json:"fields,omitempty"` // 검증에 실패한 필드별 사유
+}
+
+func itemError(id int64, outcome, format string, args ...any) itemResult {
+	return itemResult{Id: id, Outcome: outcome, Error: fmt.Sprintf(format, args...)}
+}
+
+func versionConflict(id int64, expected, current int64) itemResult {
+	return itemError(id, outcomeConflict, "version mismatch: expected %d, current %d", expected, current)
+}
+
+func invalidRecord(id int64, errs []fieldError) itemResult {
+	res := itemError(id, outcomeInvalid, "%s", joinFieldErrors(errs))
+	res.Fields = errs
+	return res
+}
+
+func (res itemResult) succeeded() bool {
+	switch res.Outcome {
+	case outcomeCreated, outcomeUpdated, outcomeDeleted, outcomeRestored:
+		return true
+	}
+	return false
+}
+
+// 쓰기 요청에 대한 응답 본문
+type writeResponse struct {
+	Mode    string         `json:"mode,omitempty"`
+	Version uint64         `json:"version"`           // 처리 후의 데이터셋 버전 (ETag와 같은 값)
+	Count   int            `json:"count"`             // 처리 후 TxData의 개수
+	Summary map[string]int `json:"summary"`           // 결과(outcome)별 항목 수
+	Results []itemResult   `json:"results,omitempty"` // 동적 스키마 컬렉션은 항목별 결과 없음
+	Error   *apiError      `json:"error,omitempty"`   // 실패 상태 코드(409, 422)일 때만
+
+	Transaction bool `json:"-"` // 트랜잭션 응답 (오류 메시지 구분용)
+}
+
+// 항목별 결과로 응답 상태 코드 결정
+// -> 모두 성공 200, 일부만 성공 207 (Multi-Status), 모두 실패 422 (버전 충돌이 있으면 409)
+func writeStatus(results []itemResult) int {
+	succeeded, conflicts := 0, 0
+	for _, res := range results {
+		if res.succeeded() {
+			succeeded++
+		} else if res.Outcome == outcomeConflict {
+			conflicts++
+		}
+	}
+	switch {
+	case succeeded == len(results):
+		return http.StatusOK
+	case succeeded == 0 && conflicts > 0:
+		return http.StatusConflict
+	case succeeded == 0:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusMultiStatus
+	}
+}
+
+// 모든 오류 응답의 형식 -> {"error": {"code": ..., "message": ..., "details": ..., "request_id": ...}}
+type apiError struct {
+	Code      string `json:"code"` // 상태 코드별 기본값 (errorCodes) 또는 항목 결과(outcome)
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestId string `json:"request_id,omitempty"` // 응답의 X-Request-Id와 같은 값 (서버 로그와 대조용)
+}
+
+type errorResponse struct {
+	Error *apiError `json:"error"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+func newAPIError(w http.ResponseWriter, status int, code, message string, details any) *apiError {
+	if code == "" {
+		code = errorCodes[status]
+	}
+	return &apiError{Code: code, Message: message, Details: details, RequestId: w.Header().Get("X-Request-Id")}
+}
+
+// 요청 자체를 처리할 수 없을 때 (본문 형식 오류 등) 오류 형식으로 사유 응답
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeAPIError(w, status, "", message, nil)
+}
+
+// code가 비어 있으면 상태 코드의 기본 code 사용
+func writeAPIError(w http.ResponseWriter, status int, code, message string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: newAPIError(w, status, code, message, details)})
+}
+
+// 405 + Allow 헤더 (경로별 메서드는 ServeMux 패턴이 먼저 걸러내므로, 한 핸들러가 여러 메서드를 받는 경우에만 사용)
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	log.Println("Method not allowed")
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// If-Match가 현재 버전과 다를 때 (412) -> 클라이언트가 다시 조회할 수 있도록 현재 버전을 알려줌
+func writePreconditionFailed(w http.ResponseWriter, version uint64) {
+	w.Header().Set("ETag", datasetETag(version))
+	writeAPIError(w, http.StatusPreconditionFailed, "", fmt.Sprintf("dataset has changed (current version %d)", version), map[string]uint64{"current_version": version})
+}
+
+// /data/{id}의 If-Match가 레코드의 현재 ETag와 다를 때 (412), d가 nil이면 레코드가 없음
+func writeRecordPreconditionFailed(w http.ResponseWriter, d *pt.Data) {
+	if d == nil {
+		writeAPIError(w, http.StatusPreconditionFailed, "", "record does not exist", nil)
+		return
+	}
+	w.Header().Set("ETag", recordETag(d))
+	writeAPIError(w, http.StatusPreconditionFailed, "", fmt.Sprintf("record has changed (current version %d)", d.Version), map[string]int64{"current_version": d.Version})
+}
+
+// 데이터를 만들지 못했을 때 (500)
+func writeInternalError(w http.ResponseWriter, err error) {
+	log.Printf("Internal error: %v", err)
+	writeJSONError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// 쓰기 결과 응답 -> 실패 상태 코드면 결과와 함께 error도 채움
+func writeResults(w http.ResponseWriter, status int, response writeResponse) {
+	if status >= 400 {
+		message := "no items were applied"
+		if response.Transaction {
+			message = "transaction rolled back"
+		}
+		response.Error = newAPIError(w, status, "", message, nil)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", datasetETag(response.Version))
+	w.Header().Set("X-Dataset-Version", strconv.FormatUint(response.Version, 10)) // Rx에서 ?min_version=으로 기다릴 때 사용
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 모든 요청에 request ID를 붙이고 (X-Request-Id, 요청에 있으면 그대로 사용),
+// ServeMux가 직접 응답하는 404/405와 panic도 같은 오류 형식으로 응답
+func withRequestId(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get("X-Request-Id")
+		if requestId == "" {
+			requestId = newRequestId()
+		}
+		w.Header().Set("X-Request-Id", requestId)
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("Panic while handling %s %s (request %s): %v", r.Method, r.URL.Path, requestId, err)
+				writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+
+		handler, pattern := mux.Handler(r)
+		if pattern == "" { // 맞는 경로가 없음 -> ServeMux의 응답에서 상태 코드와 헤더(Allow, Location)만 사용
+			capture := &headerCapture{header: make(http.Header), status: http.StatusOK}
+			handler.ServeHTTP(capture, r)
+			for _, key := range []string{"Allow", "Location"} {
+				if value := capture.header.Get(key); value != "" {
+					w.Header().Set(key, value)
+				}
+			}
+			if capture.status < 400 { // 경로 정리 리다이렉트 등
+				w.WriteHeader(capture.status)
+				return
+			}
+			writeJSONError(w, capture.status, strings.ToLower(http.StatusText(capture.status)))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// 응답 본문은 버리고 상태 코드와 헤더만 기록
+type headerCapture struct {
+	header http.Header
+	status int
+}
+
+func (c *headerCapture) Header() http.Header         { return c.header }
+func (c *headerCapture) Write(b []byte) (int, error) { return len(b), nil }
+func (c *headerCapture) WriteHeader(status int)      { c.status = status }
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeModeFor(r *http.Request, method string) (string, error) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		switch method {
+		case "POST":
+			return writeReplace, nil
+		case "PUT":
+			return writeUpdate, nil
+		case "PATCH":
+			return writeMerge, nil
+		}
+		return "", nil // DELETE, UNDELETE는 쓰기 방식 없음
+	}
+	switch mode {
+	case writeReplace, writeAppend, writeUpsert, writeUpdate, writeMerge:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown write mode %q", mode)
+}
+
+func dupPolicyFor(r *http.Request) (string, error) {
+	dup := r.URL.Query().Get("dup")
+	switch dup {
+	case "":
+		return dupFirst, nil
+	case dupFirst, dupLast, dupReject:
+		return dup, nil
+	}
+	return "", fmt.Errorf("unknown duplicate policy %q", dup)
+}
+
+func processTxData(w http.ResponseWriter, r *http.Request, c *txCollection, method string) {
+	mode, err := writeModeFor(r, method)
+	if err != nil {
+		log.Printf("Invalid request: %v", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dup, err := dupPolicyFor(r)
+	if err != nil {
+		log.Printf("Invalid request: %v", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 여러 개의 데이터를 처리하도록 수정 (슬라이스 적용)
+	// 클라이언트가 보낸 데이터 목록 -> Content-Type에 맞게 디코딩해 구조체(sData) 형태로
+	dataList, status, err := decodeDataList(r)
+	if err != nil {
+		log.Printf("Invalid data format: %v", err)
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	response, _, ok := commitTxWrite(c, r, method, mode, dup, dataList)
+	if !ok {
+		writePreconditionFailed(w, response.Version)
+		return
+	}
+	// 클라이언트에게 항목별 처리 결과 응답
+	writeResults(w, writeStatus(response.Results), response)
+}
+
+// 쓰기 요청을 c에 반영하고, 바뀐 것이 있으면 Rx로 전송 (배치 경로와 단건 경로가 함께 사용)
+// If-Match가 현재 버전과 다르면 아무것도 하지 않고 false (응답의 Version은 현재 버전)
+// 성공하면 응답 본문과 반영 후의 데이터(툼스톤 포함)를 반환
+func commitTxWrite(c *txCollection, r *http.Request, method, mode, dup string, dataList []sData) (writeResponse, []*pt.Data, bool) {
+	start := time.Now()
+	c.mu.Lock()
+	current := datasetETag(c.version)
+	if r.PathValue("id") != "" { // 레코드 하나의 경로 -> 그 레코드의 ETag와 비교 (다른 레코드가 바뀌어도 영향 없음)
+		current = recordETag(findRecord(c.data, dataList[0].Id))
+	}
+	if !ifMatchOK(r, current) {
+		version := c.version
+		c.mu.Unlock()
+		log.Printf("%s request rejected: If-Match %s, current ETag %s", method, r.Header.Get("If-Match"), current)
+		return writeResponse{Version: version}, nil, false
+	}
+	before := append([]*pt.Data(nil), c.data...) // 이력 기록용 (writeTxData는 c.data를 직접 수정)
+	var results []itemResult
+	if method == "DELETE" {
+		results = deleteTxData(c, dataList, dup)
+	} else if method == "UNDELETE" {
+		results = restoreTxData(c, dataList, dup)
+	} else {
+		results = writeTxData(c, dataList, mode, dup)
+	}
+	changed := mode == writeReplace && len(dataList) == 0 // 빈 목록으로 replace하면 기존 데이터만 지움
+	for _, res := range results {
+		if res.succeeded() {
+			changed = true
+		}
+	}
+	// 잠금을 풀기 전에 복사해 둔다 -> 전송 중에 다른 요청이 TxData를 바꿔도 영향 없도록
+	snapshot := append([]*pt.Data(nil), c.data...)
+	version := c.version
+	if changed {
+		// Rx 서버로 데이터 패키지 전송 (바뀐 것이 없으면 생략)
+		recordHistory(c, before, c.data, c.version+1, changedBy(r))
+		dataPackage := &pt.DataPackage{
+			DataList:   snapshot,             // 여러 개의 pt.Data 구조체를 가진 슬라이스
+			TotalCount: int32(len(snapshot)), //  TxData에 포함된 데이터 항목의 개수
+		}
+		version = commitAndReplicate(c, dataPackage, diffChanges(before, c.data, c.version+1))
+	} else {
+		c.mu.Unlock()
+	}
+	end := time.Since(start)
+
+	summary := make(map[string]int)
+	for _, res := range results {
+		summary[res.Outcome]++
+		if !res.succeeded() {
+			log.Printf("%s request (%s): ID %d %s, skipping.\n", method, mode, res.Id, res.Outcome)
+		}
+	}
+	log.Printf("%s request (%s) processed for %d data.\n", method, mode, len(dataList))
+	log.Printf("Current TxData [%s]: %+v\n", c.name, snapshot)                            // TxData 출력
+	fmt.Printf("-- Tx_Time elapsed for %s request: %d ms.\n", method, end.Milliseconds()) // 소요 시간 출력
+	return writeResponse{Mode: mode, Version: version, Count: len(liveData(snapshot)), Summary: summary, Results: results}, snapshot, true
+}
+
+// 대용량 가져오기 (POST /import) ------------------------------------------------------
+// 예: /import?mode=upsert&chunk=1000 (본문은 NDJSON 또는 JSON 배열)
+// -> 본문 전체를 메모리에 올리지 않고 레코드를 하나씩 읽어 chunk 개씩 반영하고, 반영한 레코드만 트랜잭션으로 Rx에 전송
+// -> 청크마다 커밋되므로 중간에 본문이 잘못되면 그 앞까지는 반영된 상태로 남음
+
+const (
+	defaultImportChunk = 1000
+	maxImportChunk     = 10000
+	maxImportErrors    = 100 // 응답에 담는 실패 항목 수 (나머지는 summary에만 집계)
+)
+
+// 요청 본문에서 레코드를 하나씩 읽음 (JSON 배열은 토큰 단위로, NDJSON은 줄 단위로)
+type recordStream struct {
+	decoder *json.Decoder
+	array   bool
+	count   int // 지금까지 읽은 레코드 수
+}
+
+func newRecordStream(r *http.Request) (*recordStream, int, error) {
+	format, err := requestFormat(r)
+	if err == nil && format != formatJSON && format != formatNDJSON {
+		err = fmt.Errorf("import accepts %s or %s, got %s", formatNDJSON, formatJSON, format)
+	}
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+	stream := &recordStream{decoder: json.NewDecoder(r.Body), array: format == formatJSON}
+	if stream.array {
+		if token, err := stream.decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid data format: expected a JSON array")
+		}
+	}
+	return stream, 0, nil
+}
+
+// 다음 레코드 (본문 끝이면 false)
+func (s *recordStream) next() (sData, bool, error) {
+	if s.array && !s.decoder.More() {
+		if _, err := s.decoder.Token(); err != nil { // 닫는 ']'
+			return sData{}, false, fmt.Errorf("invalid data format: %v", err)
+		}
+		return sData{}, false, nil
+	}
+	var raw json.RawMessage
+	if err := s.decoder.Decode(&raw); err == io.EOF && !s.array {
+		return sData{}, false, nil
+	} else if err != nil {
+		return sData{}, false, fmt.Errorf("invalid data format (record %d): %v", s.count+1, err)
+	}
+	s.count++
+	data, err := unmarshalJSONRecord(raw)
+	if err != nil {
+		return sData{}, false, fmt.Errorf("invalid data format (record %d): %v", s.count, err)
+	}
+	return data, true, nil
+}
+
+func handleTxImport(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "":
+		mode = writeUpsert
+	case writeAppend, writeUpsert, writeUpdate, writeMerge:
+	default: // replace는 청크마다 기존 데이터를 지우게 되므로 지원하지 않음
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("import mode must be append, upsert, update or merge, got %q", mode))
+		return
+	}
+	dup, err := dupPolicyFor(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chunkSize := defaultImportChunk
+	if v := r.URL.Query().Get("chunk"); v != "" {
+		if chunkSize, err = strconv.Atoi(v); err != nil || chunkSize < 1 || chunkSize > maxImportChunk {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("chunk must be between 1 and %d", maxImportChunk))
+			return
+		}
+	}
+	stream, status, err := newRecordStream(r)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	start := time.Now()
+	response := writeResponse{Mode: mode, Summary: make(map[string]int)}
+	chunk := make([]sData, 0, chunkSize)
+	chunks, total := 0, 0
+	commit := func() bool {
+		results, version, ok := commitImportChunk(c, r, mode, dup, chunk, chunks == 0)
+		if !ok { // If-Match는 첫 청크에서만 확인
+			writePreconditionFailed(w, version)
+			return false
+		}
+		for _, res := range results {
+			response.Summary[res.Outcome]++
+			if !res.succeeded() && len(response.Results) < maxImportErrors {
+				response.Results = append(response.Results, res)
+			}
+		}
+		response.Version = version
+		chunks++
+		total += len(chunk)
+		chunk = chunk[:0] // 같은 버퍼를 다시 사용 -> 입력 크기와 상관없이 청크 하나만큼의 메모리
+		return true
+	}
+	for {
+		data, ok, err := stream.next()
+		if err != nil {
+			log.Printf("Import stopped after %d records: %v", total, err)
+			writeAPIError(w, http.StatusBadRequest, "", err.Error(), map[string]any{
+				"imported": total, // 이미 커밋된 레코드 수 (오류 전 청크까지)
+				"version":  response.Version,
+				"summary":  response.Summary,
+			})
+			return
+		}
+		if !ok {
+			break
+		}
+		chunk = append(chunk, data)
+		if len(chunk) == chunkSize && !commit() {
+			return
+		}
+	}
+	if (len(chunk) > 0 || chunks == 0) && !commit() {
+		return
+	}
+	c.mu.RLock() // 레코드 개수는 청크마다 세지 않고 끝난 뒤에 한 번만
+	response.Count = len(liveData(c.data))
+	c.mu.RUnlock()
+	log.Printf("Import (%s) processed %d records in %d chunks.\n", mode, total, chunks)
+	fmt.Printf("-- Tx_Time elapsed for import: %d ms.\n", time.Since(start).Milliseconds())
+
+	succeeded := response.Summary[outcomeCreated] + response.Summary[outcomeUpdated]
+	status = http.StatusOK
+	switch {
+	case succeeded == 0 && total > 0:
+		status = http.StatusUnprocessableEntity
+	case succeeded < total:
+		status = http.StatusMultiStatus
+	}
+	writeResults(w, status, response)
+}
+
+// 청크 하나를 c에 반영하고 바뀐 레코드를 트랜잭션으로 Rx에 전송 -> 반영 후의 버전을 반환
+// -> 작업, 이력, 이벤트는 청크의 결과에서만 만들므로 이미 쌓인 데이터 크기와 상관없이 청크 크기에 비례
+// checkIfMatch면 If-Match가 현재 버전과 다를 때 아무것도 하지 않고 false
+func commitImportChunk(c *txCollection, r *http.Request, mode, dup string, chunk []sData, checkIfMatch bool) ([]itemResult, uint64, bool) {
+	c.mu.Lock()
+	if checkIfMatch && !ifMatchOK(r, datasetETag(c.version)) {
+		version := c.version
+		c.mu.Unlock()
+		log.Printf("Import rejected: If-Match %s, current version %d", r.Header.Get("If-Match"), version)
+		return nil, version, false
+	}
+	results := writeTxData(c, chunk, mode, dup)
+	tx := &pt.Transaction{}
+	now, by := time.Now(), changedBy(r)
+	var events []changeEvent
+	for _, res := range results {
+		kind, change, op := pt.Operation_UPDATE, "updated", opUpdate
+		switch res.Outcome {
+		case outcomeCreated:
+			kind, change, op = pt.Operation_CREATE, "created", opInsert
+		case outcomeUpdated:
+		default:
+			continue
+		}
+		d := c.data[c.index[res.Id]]
+		tx.Operations = append(tx.Operations, &pt.Operation{Kind: kind, Data: d})
+		appendHistory(c, d.Id, historyEntry{DatasetVersion: c.version + 1, At: now, ChangedBy: by, Change: change, record: d})
+		events = append(events, changeEvent{Version: c.version + 1, Op: op, Id: d.Id, record: d})
+	}
+	if len(tx.Operations) == 0 {
+		version := c.version
+		c.mu.Unlock()
+		return results, version, true
+	}
+	dataPackage := &pt.DataPackage{
+		Transaction: tx,
+		TotalCount:  int32(len(c.data)), // 적용 후의 전체 데이터 개수
+	}
+	return results, commitAndReplicate(c, dataPackage, events), true
+}
+
+// 목록 조회 (GET /data) -> 필터, 정렬, 페이지 ----------------------------------------
+// 예: /data?sex=Female&name_prefix=Al&sort=-updated_at&limit=50
+// -> 다음 페이지가 있으면 Link: <...&cursor=...>; rel="next" (X-Next-Cursor에도 같은 커서)
+
+const maxListLimit = 1000
+
+// 정렬할 수 있는 필드 -> 레코드의 정렬 값 (숫자 또는 문자열)
+var sortFields = map[string]func(d *pt.Data) (int64, string){
+	"id":         func(d *pt.Data) (int64, string) { return d.Id, "" },
+	"name":       func(d *pt.Data) (int64, string) { return 0, d.Name },
+	"created_at": func(d *pt.Data) (int64, string) { return d.CreatedAt.AsTime().UnixNano(), "" },
+	"updated_at": func(d *pt.Data) (int64, string) { return d.UpdatedAt.AsTime().UnixNano(), "" },
+}
+
+type listQuery struct {
+	limit int // 0이면 전체
+
+	namePrefix      string
+	sex             string
+	addressContains string // 대소문자 구분 없음
+	idMin, idMax    int64  // 0이면 제한 없음
+
+	sort string // 정렬 필드
+	desc bool
+
+	key    string      // 정렬과 필터의 해시 -> 커서를 다른 조건에 쓰지 않도록 확인
+	cursor *listCursor // 이 위치 다음부터
+}
+
+// 이전 페이지의 마지막 항목 위치 (정렬 값 + ID)
+// -> 순번(offset)이 아니라 값으로 이어가므로, 페이지 사이에 앞쪽 데이터가 추가/삭제되어도 중복되거나 빠지지 않음
+type listCursor struct {
+	Key string `json:"k"`
+	Num int64  `json:"n,omitempty"`
+	Str string `json:"s,omitempty"`
+	Id  int64  `json:"id"`
+}
+
+func parseListQuery(values url.Values) (*listQuery, error) {
+	q := &listQuery{sort: "id"}
+	var err error
+	if v := values.Get("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit <= 0 || q.limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+	}
+	q.namePrefix = values.Get("name_prefix")
+	q.addressContains = strings.ToLower(values.Get("address_contains"))
+	if sex := values.Get("sex"); sex != "" { // 예전 이름과 enum 이름 모두 받음 -> 커서 비교를 위해 한 가지로 맞춤
+		if q.sex = sexNames[sexFromString(sex)]; q.sex == "" {
+			return nil, fmt.Errorf("sex must be one of Male, Female, Other (or SEX_MALE, SEX_FEMALE, SEX_OTHER)")
+		}
+	}
+	for name, target := range map[string]*int64{"id_min": &q.idMin, "id_max": &q.idMax} {
+		if v := values.Get(name); v != "" {
+			if *target, err = strconv.ParseInt(v, 10, 64); err != nil || *target <= 0 {
+				return nil, fmt.Errorf("%s must be a positive integer", name)
+			}
+		}
+	}
+	if v := values.Get("sort"); v != "" {
+		q.sort, q.desc = strings.CutPrefix(v, "-")
+		if _, ok := sortFields[q.sort]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q (id, name, created_at, updated_at; prefix - for descending)", q.sort)
+		}
+	}
+	key := fnv.New64a()
+	for _, part := range []string{q.namePrefix, q.sex, q.addressContains, strconv.FormatInt(q.idMin, 10), strconv.FormatInt(q.idMax, 10), q.sort, strconv.FormatBool(q.desc)} {
+		key.Write([]byte(part))
+		key.Write([]byte{0})
+	}
+	q.key = strconv.FormatUint(key.Sum64(), 36)
+
+	if v := values.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		var cursor listCursor
+		if err == nil {
+			err = json.Unmarshal(raw, &cursor)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Key != q.key {
+			return nil, fmt.Errorf("cursor was issued for different filters or sort order")
+		}
+		q.cursor = &cursor
+	}
+	return q, nil
+}
+
+func (q *listQuery) matches(d *pt.Data) bool {
+	return strings.HasPrefix(d.Name, q.namePrefix) &&
+		(q.sex == "" || sexString(d) == q.sex) &&
+		(q.addressContains == "" || strings.Contains(strings.ToLower(d.Address), q.addressContains)) &&
+		(q.idMin == 0 || d.Id >= q.idMin) &&
+		(q.idMax == 0 || d.Id <= q.idMax)
+}
+
+// 정렬 순서에서 (num, str, id) 위치 비교
+func (q *listQuery) compare(aNum int64, aStr string, aId int64, bNum int64, bStr string, bId int64) int {
+	result := cmp.Or(cmp.Compare(aNum, bNum), strings.Compare(aStr, bStr), cmp.Compare(aId, bId))
+	if q.desc {
+		return -result
+	}
+	return result
+}
+
+// 한 페이지 분량의 결과
+type listPage struct {
+	data  []*pt.Data
+	total int    // 필터에 맞는 전체 개수
+	next  string // 다음 페이지 커서 (마지막 페이지면 빈 문자열)
+}
+
+// 호출하는 쪽에서 dataList를 잠근 상태여야 함 (반환하는 레코드는 수정되지 않으므로 잠금을 푼 뒤에 사용 가능)
+func (q *listQuery) apply(dataList []*pt.Data) listPage {
+	sortValue := sortFields[q.sort]
+	matched := make([]*pt.Data, 0, len(dataList))
+	for _, d := range dataList {
+		if q.matches(d) {
+			matched = append(matched, d)
+		}
+	}
+	slices.SortFunc(matched, func(a, b *pt.Data) int {
+		aNum, aStr := sortValue(a)
+		bNum, bStr := sortValue(b)
+		return q.compare(aNum, aStr, a.Id, bNum, bStr, b.Id)
+	})
+
+	page := listPage{total: len(matched), data: matched}
+	if q.cursor != nil {
+		start, _ := slices.BinarySearchFunc(matched, q.cursor, func(d *pt.Data, c *listCursor) int {
+			num, str := sortValue(d)
+			if q.compare(num, str, d.Id, c.Num, c.Str, c.Id) <= 0 {
+				return -1 // 커서 위치까지는 이전 페이지
+			}
+			return 1
+		})
+		page.data = matched[start:]
+	}
+	if q.limit > 0 && len(page.data) > q.limit {
+		page.data = page.data[:q.limit]
+		last := page.data[len(page.data)-1]
+		num, str := sortValue(last)
+		raw, _ := json.Marshal(listCursor{Key: q.key, Num: num, Str: str, Id: last.Id})
+		page.next = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return page
+}
+
+// X-Total-Count와 다음 페이지 링크
+func (p listPage) setHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.total))
+	if p.next == "" {
+		return
+	}
+	next := *r.URL
+	values := next.Query()
+	values.Set("cursor", p.next)
+	next.RawQuery = values.Encode()
+	w.Header().Set("X-Next-Cursor", p.next)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+// 형식 협상 -> 응답은 Accept, 요청 본문은 Content-Type으로 형식 결정 (없으면 JSON) ---------------
+
+const (
+	formatJSON      = "application/json"       // 레코드 배열 (marshalJSONList)
+	formatProtobuf  = "application/x-protobuf" // DataPackage (단건은 Data)
+	formatProtoJSON = "application/protojson"  // DataPackage (단건은 Data)를 protojson으로
+	formatNDJSON    = "application/x-ndjson"   // 한 줄에 레코드 하나 (marshalJSONRecord)
+	formatCSV       = "text/csv"               // 첫 줄은 헤더 (csvColumns)
+)
+
+var dataFormats = []string{formatJSON, formatProtobuf, formatProtoJSON, formatNDJSON, formatCSV}
+
+// Accept에서 가장 선호하는 지원 형식 (q 값 반영, */*나 헤더가 없으면 JSON)
+func responseFormat(r *http.Request) (string, error) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		format := mediaType
+		if mediaType == "*/*" || mediaType == "application/*" {
+			format = formatJSON
+		}
+		if slices.Contains(dataFormats, format) && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("none of the accepted types are supported (%s)", strings.Join(dataFormats, ", "))
+	}
+	return best, nil
+}
+
+// 요청 본문 형식 (Content-Type이 없으면 JSON)
+func requestFormat(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return formatJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(dataFormats, mediaType) {
+		return "", fmt.Errorf("unsupported content type %q (%s)", contentType, strings.Join(dataFormats, ", "))
+	}
+	return mediaType, nil
+}
+
+func encodeList(format string, dataList []*pt.Data, version uint64) ([]byte, error) {
+	switch format {
+	case formatProtobuf, formatProtoJSON:
+		dataPackage := &pt.DataPackage{DataList: dataList, TotalCount: int32(len(dataList)), Version: version}
+		if format == formatProtobuf {
+			return proto.Marshal(dataPackage)
+		}
+		dataPackage.DataList = make([]*pt.Data, len(dataList))
+		for i, d := range dataList {
+			dataPackage.DataList[i] = withoutLegacySex(d)
+		}
+		return marshalCanonical(dataPackage)
+	case formatNDJSON:
+		var buf []byte
+		for _, d := range dataList {
+			record, err := marshalJSONRecord(d)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(append(buf, record...), '\n')
+		}
+		return buf, nil
+	case formatCSV:
+		return encodeCSV(dataList)
+	}
+	return marshalJSONList(dataList)
+}
+
+func encodeRecord(format string, d *pt.Data) ([]byte, error) {
+	switch format {
+	case formatProtobuf:
+		return proto.Marshal(d)
+	case formatProtoJSON:
+		return marshalCanonical(d)
+	case formatNDJSON, formatCSV:
+		return encodeList(format, []*pt.Data{d}, 0)
+	}
+	return marshalJSONRecord(d)
+}
+
+// 요청 본문 -> sData 목록 (형식 오류는 400, 지원하지 않는 형식은 415)
+func decodeDataList(r *http.Request) ([]sData, int, error) {
+	format, err := requestFormat(r)
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+	var dataList []sData
+	switch format {
+	case formatProtobuf, formatProtoJSON:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("failed to read body: %v", err)
+		}
+		var dataPackage pt.DataPackage
+		if format == formatProtobuf {
+			err = proto.Unmarshal(body, &dataPackage)
+		} else {
+			err = protojson.Unmarshal(body, &dataPackage)
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid data format: %v", err)
+		}
+		for _, d := range dataPackage.DataList {
+			upgradeData(d)
+			dataList = append(dataList, toJSONData(d))
+		}
+	case formatNDJSON:
+		decoder := json.NewDecoder(r.Body)
+		for {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("invalid data format (line %d): %v", len(dataList)+1, err)
+			}
+			data, err := unmarshalJSONRecord(raw)
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("invalid data format (line %d): %v", len(dataList)+1, err)
+			}
+			dataList = append(dataList, data)
+		}
+	case formatCSV:
+		if dataList, err = decodeCSV(r.Body); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid data format: %v", err)
+		}
+	default:
+		var rawList []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&rawList); err != nil { // HTTP 요청의 본문 (r.Body)에서 데이터를 읽어와서 rawList 변수에 파싱
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid data format: %v", err)
+		}
+		for i, raw := range rawList {
+			data, err := unmarshalJSONRecord(raw)
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("invalid data format (item %d): %v", i, err)
+			}
+			dataList = append(dataList, data)
+		}
+	}
+	return dataList, 0, nil
+}
+
+// CSV 열 -> 속성은 "key=value;key=value" (키 순서로 정렬), 시각은 RFC 3339
+// ttl은 요청 전용이라 응답에는 항상 빈 값
+var csvColumns = []string{"id", "name", "address", "sex", "version", "created_at", "updated_at", "expires_at", "ttl", "attributes"}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func encodeCSV(dataList []*pt.Data) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(csvColumns)
+	for _, d := range dataList {
+		writer.Write(csvRow(d))
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func csvRow(d *pt.Data) []string {
+	data := toJSONData(d)
+	attrs := make([]string, 0, len(data.Attributes))
+	for _, key := range slices.Sorted(maps.Keys(data.Attributes)) {
+		attrs = append(attrs, key+"="+data.Attributes[key])
+	}
+	return []string{
+		strconv.FormatInt(data.Id, 10), data.Name, data.Address, data.Sex, strconv.FormatInt(data.Version, 10),
+		formatCSVTime(data.CreatedAt), formatCSVTime(data.UpdatedAt), formatCSVTime(data.ExpiresAt), "", strings.Join(attrs, ";"),
+	}
+}
+
+// 첫 줄의 헤더로 열을 찾음 -> 필요한 열만 보내도 됨 (created_at, updated_at은 Tx가 관리하므로 무시)
+func decodeCSV(body io.Reader) ([]sData, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header row: %v", err)
+	}
+	for _, column := range header {
+		if !slices.Contains(csvColumns, column) {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+	var dataList []sData
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return dataList, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var data sData
+		for i, value := range record {
+			if value == "" {
+				continue
+			}
+			switch header[i] {
+			case "id":
+				data.Id, err = strconv.ParseInt(value, 10, 64)
+			case "name":
+				data.Name = value
+			case "address":
+				data.Address = value
+			case "sex":
+				data.Sex = value
+			case "version":
+				data.Version, err = strconv.ParseInt(value, 10, 64)
+			case "expires_at":
+				var expiresAt time.Time
+				expiresAt, err = time.Parse(time.RFC3339Nano, value)
+				data.ExpiresAt = &expiresAt
+			case "ttl":
+				data.TTL = value
+			case "attributes":
+				data.Attributes = make(map[string]string)
+				for _, pair := range strings.Split(value, ";") {
+					key, attr, ok := strings.Cut(pair, "=")
+					if !ok {
+						err = fmt.Errorf("attribute %q must be key=value", pair)
+						break
+					}
+					data.Attributes[key] = attr
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %s: %v", line, header[i], err)
+			}
+		}
+		dataList = append(dataList, data)
+	}
+}
+
+// 내보내기 (GET /export) -> 전체 데이터를 레코드 단위로 직렬화하며 바로 전송 ---------------------
+// 예: /export?format=csv, Tx는 /export?version=5 (또는 at=...)로 과거 시점도 가능
+// -> 응답 전체를 메모리에 만들지 않음, 버전은 ETag와 X-Dataset-Version, 파일 이름 (default-v5.ndjson)에 포함
+
+// ?format= 값 -> 형식과 파일 확장자 (protobuf는 Data를 varint 길이 접두사로 이어 붙인 형식, protodelim)
+var exportFormats = map[string]struct{ mediaType, ext string }{
+	"ndjson":   {formatNDJSON, "ndjson"},
+	"csv":      {formatCSV, "csv"},
+	"protobuf": {formatProtobuf, "pb"},
+}
+
+// ?format=이 있으면 그 형식 (잘못된 값은 400), 없으면 Accept (JSON이나 */*는 NDJSON으로, 지원하지 않으면 406)
+func exportFormat(r *http.Request) (string, string, int, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		format, ok := exportFormats[name]
+		if !ok {
+			return "", "", http.StatusBadRequest, fmt.Errorf("format must be ndjson, csv or protobuf, got %q", name)
+		}
+		return format.mediaType, format.ext, 0, nil
+	}
+	mediaType, err := responseFormat(r)
+	if err != nil {
+		return "", "", http.StatusNotAcceptable, err
+	}
+	for _, format := range exportFormats {
+		if format.mediaType == mediaType {
+			return format.mediaType, format.ext, 0, nil
+		}
+	}
+	if mediaType == formatJSON {
+		return formatNDJSON, "ndjson", 0, nil
+	}
+	return "", "", http.StatusNotAcceptable, fmt.Errorf("export is not available as %s (ndjson, csv or protobuf)", mediaType)
+}
+
+func writeExport(w http.ResponseWriter, r *http.Request, collection string, version uint64, dataList []*pt.Data) {
+	format, ext, status, err := exportFormat(r)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", datasetETag(version))
+	w.Header().Set("X-Dataset-Version", strconv.FormatUint(version, 10))
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(dataList)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-v%d.%s\"", collection, version, ext))
+
+	start := time.Now()
+	out := bufio.NewWriter(w)
+	var csvWriter *csv.Writer
+	if format == formatCSV {
+		csvWriter = csv.NewWriter(out)
+		csvWriter.Write(csvColumns)
+	}
+	for _, d := range dataList {
+		switch format {
+		case formatCSV:
+			err = csvWriter.Write(csvRow(d))
+		case formatProtobuf:
+			_, err = protodelim.MarshalTo(out, d)
+		default:
+			var record []byte
+			if record, err = marshalJSONRecord(d); err == nil {
+				record = append(record, '\n')
+				_, err = out.Write(record)
+			}
+		}
+		if err != nil { // 헤더를 이미 보냈으므로 상태 코드는 바꿀 수 없음 -> 연결을 끊어 클라이언트가 알 수 있도록
+			log.Printf("Export of %q aborted: %v", collection, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("Export of %q aborted: %v", collection, err)
+		return
+	}
+	log.Printf("Exported %d records of %q (version %d) as %s.", len(dataList), collection, version, format)
+	fmt.Printf("-- Time elapsed for export: %d ms.\n", time.Since(start).Milliseconds())
+}
+
+func handleTxExport(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	query := r.URL.Query()
+	if query.Has("version") || query.Has("at") { // 과거 시점 (/snapshot과 같은 규칙)
+		dataList, version, status, err := snapshotAt(c, query)
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		writeExport(w, r, c.name, version, dataList)
+		return
+	}
+	c.mu.RLock()
+	version := c.version
+	dataList := liveData(c.data) // 레코드 포인터만 복사 (레코드는 바뀌지 않고 새로 만들어짐)
+	c.mu.RUnlock()
+	writeExport(w, r, c.name, version, dataList)
+}
+
+func handleRxExport(w http.ResponseWriter, r *http.Request) {
+	name, err := collectionName(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := rxCollectionFor(name, false)
+	if c == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q not found", name))
+		return
+	}
+	c.mu.RLock()
+	dynamic := c.schema != nil
+	version := c.version
+	dataList := liveData(c.data)
+	c.mu.RUnlock()
+	if dynamic {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("collection %q has a dynamic schema; export is not supported", name))
+		return
+	}
+	writeExport(w, r, name, version, dataList)
+}
+
+// 단건 경로 (/data, /data/{id}) ------------------------------------------------
+
+// 경로의 {id} -> 양의 정수가 아니면 400을 쓰고 false
+func recordId(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// 삭제되지 않은 레코드 중 id를 찾음 (없으면 nil)
+func findRecord(dataList []*pt.Data, id int64) *pt.Data {
+	for _, d := range dataList {
+		if d.Id == id && d.DeletedAt == nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// 레코드 하나를 Accept에 맞는 형식으로 응답 (ETag는 레코드 버전, X-Dataset-Version은 데이터셋 버전)
+func writeRecord(w http.ResponseWriter, r *http.Request, status int, version uint64, d *pt.Data) {
+	format, err := responseFormat(r)
+	if err != nil {
+		writeJSONError(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	body, err := encodeRecord(format, d)
+	if err != nil {
+		writeInternalError(w, fmt.Errorf("failed to encode record: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", recordETag(d))
+	w.Header().Set("X-Dataset-Version", strconv.FormatUint(version, 10))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// 레코드 하나의 본문 -> 배열이 아닌 객체 하나
+// (protobuf는 Data 하나, NDJSON과 CSV는 레코드가 하나만 든 목록)
+func decodeRecord(w http.ResponseWriter, r *http.Request) (sData, bool) {
+	var data sData
+	format, err := requestFormat(r)
+	switch {
+	case err == nil && format == formatJSON:
+		raw, err := io.ReadAll(r.Body)
+		if err == nil {
+			data, err = unmarshalJSONRecord(raw)
+		}
+		if err != nil {
+			log.Printf("Invalid data format: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid data format (expected one JSON object): %v", err))
+			return data, false
+		}
+		return data, true
+	case err == nil && (format == formatProtobuf || format == formatProtoJSON):
+		body, err := io.ReadAll(r.Body)
+		d := &pt.Data{}
+		if err == nil && format == formatProtobuf {
+			err = proto.Unmarshal(body, d)
+		} else if err == nil {
+			err = protojson.Unmarshal(body, d)
+		}
+		if err != nil {
+			log.Printf("Invalid data format: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid data format (expected one Data message): %v", err))
+			return data, false
+		}
+		upgradeData(d)
+		return toJSONData(d), true
+	}
+	dataList, status, err := decodeDataList(r)
+	if err == nil && len(dataList) != 1 {
+		status, err = http.StatusBadRequest, fmt.Errorf("expected exactly one record, got %d", len(dataList))
+	}
+	if err != nil {
+		log.Printf("Invalid data format: %v", err)
+		writeJSONError(w, status, err.Error())
+		return data, false
+	}
+	return dataList[0], true
+}
+
+// GET /data/{id}
+func handleTxGetRecord(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	id, ok := recordId(w, r)
+	if !ok {
+		return
+	}
+	c.mu.RLock()
+	version := c.version
+	d := findRecord(c.data, id)
+	c.mu.RUnlock()
+	writeRecordOrNotModified(w, r, version, id, d)
+}
+
+// GET /data/{id}의 응답 (Tx, Rx 공통) -> 레코드가 바뀌지 않았으면 304
+func writeRecordOrNotModified(w http.ResponseWriter, r *http.Request, version uint64, id int64, d *pt.Data) {
+	if d == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("id %d not found", id))
+		return
+	}
+	if notModified(r, recordETag(d)) {
+		w.Header().Set("ETag", recordETag(d))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeRecord(w, r, http.StatusOK, version, d)
+}
+
+// POST /data -> 레코드 하나 생성 (id를 생략하면 서버가 할당), 201 + Location
+func handleTxCreateRecord(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	if c.schema != nil { // 동적 스키마는 배열로 받음
+		handleTxDynamicRequest(w, r, c)
+		return
+	}
+	if mode := r.URL.Query().Get("mode"); mode != "" && mode != writeAppend {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("write mode %q is not supported here (use the batch route)", mode))
+		return
+	}
+	data, ok := decodeRecord(w, r)
+	if !ok {
+		return
+	}
+	writeTxRecord(w, r, c, "POST", writeAppend, data)
+}
+
+// PUT /data/{id} (기본 update, ?mode=upsert 가능), PATCH /data/{id} (merge)
+func handleTxUpdateRecord(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	id, ok := recordId(w, r)
+	if !ok {
+		return
+	}
+	mode, err := writeModeFor(r, r.Method)
+	if err == nil && mode != writeUpdate && mode != writeMerge && mode != writeUpsert {
+		err = fmt.Errorf("write mode %q is not supported here (use the batch route)", mode)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, ok := decodeRecord(w, r)
+	if !ok {
+		return
+	}
+	if data.Id != 0 && data.Id != id {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("id %d in body does not match id %d in path", data.Id, id))
+		return
+	}
+	data.Id = id
+	writeTxRecord(w, r, c, r.Method, mode, data)
+}
+
+// DELETE /data/{id} (?version=N이면 레코드 버전 확인), 성공하면 204
+func handleTxDeleteRecord(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	id, ok := recordId(w, r)
+	if !ok {
+		return
+	}
+	data := sData{Id: id}
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || version <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "version must be a positive integer")
+			return
+		}
+		data.Version = version
+	}
+	writeTxRecord(w, r, c, "DELETE", "", data)
+}
+
+// 레코드 하나를 쓰고 결과에 맞는 상태 코드로 응답
+func writeTxRecord(w http.ResponseWriter, r *http.Request, c *txCollection, method, mode string, data sData) {
+	response, snapshot, ok := commitTxWrite(c, r, method, mode, dupFirst, []sData{data})
+	if !ok {
+		if r.PathValue("id") == "" {
+			writePreconditionFailed(w, response.Version)
+			return
+		}
+		c.mu.RLock()
+		d := findRecord(c.data, data.Id)
+		c.mu.RUnlock()
+		writeRecordPreconditionFailed(w, d)
+		return
+	}
+	res := response.Results[0]
+	switch res.Outcome {
+	case outcomeCreated:
+		location := r.URL.Path // PUT /data/{id}로 만든 경우 경로가 이미 레코드의 위치
+		if r.PathValue("id") == "" {
+			location = strings.TrimSuffix(location, "/") + "/" + strconv.FormatInt(res.Id, 10)
+		}
+		w.Header().Set("Location", location)
+		writeRecord(w, r, http.StatusCreated, response.Version, findRecord(snapshot, res.Id))
+	case outcomeUpdated:
+		writeRecord(w, r, http.StatusOK, response.Version, findRecord(snapshot, res.Id))
+	case outcomeDeleted:
+		w.Header().Set("X-Dataset-Version", strconv.FormatUint(response.Version, 10))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		status := writeStatus(response.Results)
+		if res.Outcome == outcomeNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("X-Dataset-Version", strconv.FormatUint(response.Version, 10))
+		writeAPIError(w, status, res.Outcome, res.Error, map[string]any{"id": res.Id, "fields": res.Fields})
+	}
+}
+
+// GET /data/{id} (Rx)
+func handleRxGetRecord(w http.ResponseWriter, r *http.Request) {
+	name, err := collectionName(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, ok := recordId(w, r)
+	if !ok {
+		return
+	}
+	c := rxCollectionFor(name, false)
+	if c == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q not found", name))
+		return
+	}
+	if !waitForVersion(w, r, c) {
+		return
+	}
+	c.mu.RLock()
+	version := c.version
+	d := findRecord(c.data, id)
+	c.mu.RUnlock()
+	writeRecordOrNotModified(w, r, version, id, d)
+}
+
+// 반영하지 않을 항목을 찾아 결과를 미리 채워 둔다
+// -> 잘못된 항목은 invalid, 같은 요청 안에서 중복된 ID는 dup 정책에 따라 duplicate
+// assign이 true면 (새 레코드를 만드는 요청) ID가 0인 항목은 서버가 ID를 할당하므로 통과
+func screenItems(dataList []sData, dup string, results []itemResult, assign bool) []bool {
+	skip := make([]bool, len(dataList))
+	seen := make(map[int64][]int) // ID -> 해당 ID가 나온 위치들
+	for i, data := range dataList {
+		if assign && data.Id == 0 {
+			continue
+		}
+		if data.Id <= 0 {
+			skip[i] = true
+			results[i] = itemError(data.Id, outcomeInvalid, "id must be a positive integer")
+			continue
+		}
+		if data.Id > maxClientId {
+			skip[i] = true
+			results[i] = itemError(data.Id, outcomeInvalid, "id must be at most %d", int64(maxClientId))
+			continue
+		}
+		seen[data.Id] = append(seen[data.Id], i)
+	}
+	for _, idxs := range seen {
+		if len(idxs) < 2 {
+			continue
+		}
+		for k, i := range idxs {
+			if dup == dupReject || (dup == dupFirst && k > 0) || (dup == dupLast && k < len(idxs)-1) {
+				skip[i] = true
+				results[i] = itemError(dataList[i].Id, outcomeDuplicate, "id %d appears %d times in request", dataList[i].Id, len(idxs))
+			}
+		}
+	}
+	return skip
+}
+
+// 호출하는 쪽에서 c.mu를 잠근 상태여야 함
+func writeTxData(c *txCollection, dataList []sData, mode, dup string) []itemResult {
+	results := make([]itemResult, len(dataList))
+	creates := mode == writeReplace || mode == writeAppend || mode == writeUpsert
+	skip := screenItems(dataList, dup, results, creates)
+
+	if creates {
+		// 클라이언트가 지정한 ID보다 큰 값부터 할당하도록 먼저 시퀀스를 올려 둔다
+		for i, data := range dataList {
+			if !skip[i] && data.Id > c.lastId {
+				c.lastId = data.Id
+			}
+		}
+	}
+
+	unique := newUniqueChecker() // 같은 요청 안에서 값이 겹치면 안 되는 필드 확인
+	now := timestamppb.Now()     // 이 요청에서 바뀌는 레코드는 모두 같은 시각으로 기록
+	// replace 전에 존재하던 레코드 -> 같은 ID를 다시 만들면 버전과 생성 시각을 이어받음
+	var previous map[int64]*pt.Data
+	original := c.data
+	if mode == writeReplace {
+		previous = make(map[int64]*pt.Data, len(c.data))
+		replaced := make([]*pt.Data, 0, len(c.data))
+		for _, existingData := range c.data {
+			if existingData.DeletedAt == nil {
+				previous[existingData.Id] = existingData
+				existingData = tombstone(existingData, now) // 기존 데이터는 모두 삭제 (툼스톤으로)
+			}
+			replaced = append(replaced, existingData)
+		}
+		c.data = replaced
+	}
+	// replace는 위치를 바꾸지 않으므로 c.index를 그대로 사용
+	for i, data := range dataList {
+		if skip[i] {
+			continue
+		}
+		if data.Id == 0 { // 서버가 ID 할당
+			if c.lastId == math.MaxInt64 {
+				results[i] = itemError(0, outcomeInvalid, "no more ids can be assigned")
+				continue
+			}
+			c.lastId++
+			data.Id = c.lastId
+		}
+		// dataList를 순회하며 각 구조체 요소를 *pt.Data 프로토버프 형식으로 변환.
+		txProtobuf := toProtoData(data)
+		txProtobuf.CreatedAt, txProtobuf.UpdatedAt = now, now
+		expiresAt, ferr := expiryFor(data, now.AsTime())
+		if ferr != nil {
+			results[i] = invalidRecord(data.Id, []fieldError{*ferr})
+			continue
+		}
+		txProtobuf.ExpiresAt = expiresAt
+		pos, found := c.index[txProtobuf.Id]
+		if found && data.Version != 0 && data.Version != c.data[pos].Version {
+			results[i] = versionConflict(data.Id, data.Version, c.data[pos].Version)
+			continue
+		}
+		live := found && c.data[pos].DeletedAt == nil // 툼스톤은 없는 것으로 취급
+		rec, outcome := txProtobuf, outcomeCreated    // 저장할 레코드와 결과
+		switch {
+		case live && (mode == writeReplace || mode == writeAppend):
+			results[i] = itemError(data.Id, outcomeExists, "id %d already exists", data.Id)
+			continue
+		case live && mode == writeMerge:
+			rec, outcome = mergeData(c.data[pos], data, now), outcomeUpdated
+			if expiresAt != nil { // 만료 시각도 지정한 경우에만 변경
+				rec.ExpiresAt = expiresAt
+			}
+		case live:
+			txProtobuf.Version = c.data[pos].Version + 1
+			txProtobuf.CreatedAt = c.data[pos].CreatedAt
+			outcome = outcomeUpdated
+		case mode == writeUpdate || mode == writeMerge:
+			results[i] = itemError(data.Id, outcomeNotFound, "id %d not found", data.Id)
+			continue
+		default:
+			if prev, ok := previous[txProtobuf.Id]; ok {
+				txProtobuf.Version = prev.Version + 1
+				txProtobuf.CreatedAt = prev.CreatedAt
+			} else if found { // 삭제된 ID를 다시 만들면 툼스톤의 버전에 이어서 증가
+				txProtobuf.Version = c.data[pos].Version + 1
+			} else {
+				txProtobuf.Version = 1
+			}
+		}
+
+		// 저장될 모습 그대로 검증 (merge는 합쳐진 결과를 검증)
+		errs := append(validateRecord(rec), unique.check(rec)...)
+		if len(errs) > 0 {
+			results[i] = invalidRecord(data.Id, errs)
+			continue
+		}
+		if found {
+			c.data[pos] = rec // 기존 Tx 데이터 갱신
+		} else {
+			c.index[rec.Id] = len(c.data)
+			c.data = append(c.data, rec)
+		}
+		results[i] = itemResult{Id: data.Id, Outcome: outcome}
+	}
+	// replace인데 하나도 쓰지 못했으면 기존 데이터를 지우지 않음 (빈 목록은 전체 삭제 요청이므로 그대로)
+	// -> 추가된 레코드가 없으므로 위치와 c.index도 그대로
+	if mode == writeReplace && len(dataList) > 0 && !slices.ContainsFunc(results, itemResult.succeeded) {
+		c.data = original
+	}
+	return results
+}
+
+// 비어있지 않은 필드만 덮어쓴 새 레코드를 반환 (기존 레코드는 전송 중일 수 있으므로 수정하지 않음)
+func mergeData(existingData *pt.Data, data sData, now *timestamppb.Timestamp) *pt.Data {
+	merged := proto.Clone(existingData).(*pt.Data)
+	merged.Version = existingData.Version + 1
+	merged.UpdatedAt = now
+	if data.Name != "" {
+		merged.Name = data.Name
+	}
+	if data.Address != "" {
+		merged.Address = data.Address
+	}
+	if data.Sex != "" {
+		merged.Sex, merged.LegacySex = sexFields(data.Sex)
+	}
+	// 속성은 키 단위로 합침 (값이 빈 문자열이면 해당 키 삭제)
+	for key, value := range data.Attributes {
+		if value == "" {
+			delete(merged.Attributes, key)
+			continue
+		}
+		if merged.Attributes == nil {
+			merged.Attributes = make(map[string]string)
+		}
+		merged.Attributes[key] = value
+	}
+	return merged
+}
+
+// 호출하는 쪽에서 c.mu를 잠근 상태여야 함
+// 레코드를 바로 지우지 않고 툼스톤으로 바꿈 -> 보존 기간 동안은 undelete로 되살릴 수 있음
+func deleteTxData(c *txCollection, dataList []sData, dup string) []itemResult {
+	return markTxData(c, dataList, dup, outcomeDeleted, func(d *pt.Data) bool { return d.DeletedAt == nil }, tombstone)
+}
+
+// 호출하는 쪽에서 c.mu를 잠근 상태여야 함
+func restoreTxData(c *txCollection, dataList []sData, dup string) []itemResult {
+	return markTxData(c, dataList, dup, outcomeRestored, func(d *pt.Data) bool { return d.DeletedAt != nil }, restored)
+}
+
+// 요청한 ID 중 target을 만족하는 레코드를 change의 결과로 바꾼다 (삭제, 복구 공통)
+func markTxData(c *txCollection, dataList []sData, dup, outcome string, target func(*pt.Data) bool, change func(*pt.Data, *timestamppb.Timestamp) *pt.Data) []itemResult {
+	results := make([]itemResult, len(dataList))
+	skip := screenItems(dataList, dup, results, false)
+
+	expected := make(map[int64]int64) // 대상 ID -> 기대하는 버전 (0이면 확인 안 함)
+	for i, data := range dataList {
+		if !skip[i] {
+			expected[data.Id] = data.Version
+		}
+	}
+	// 슬라이스를 한 번만 훑으면서 대상 레코드만 바꾼다
+	// (기존 슬라이스는 전송 중일 수 있으므로 새 슬라이스에 담는다)
+	now := timestamppb.Now()
+	found := make(map[int64]itemResult)
+	updated := make([]*pt.Data, 0, len(c.data))
+	for _, existingData := range c.data {
+		id := existingData.Id
+		if want, ok := expected[id]; ok && target(existingData) {
+			if want == 0 || want == existingData.Version {
+				found[id] = itemResult{Id: id, Outcome: outcome}
+				existingData = change(existingData, now)
+			} else {
+				found[id] = versionConflict(id, want, existingData.Version)
+			}
+		}
+		updated = append(updated, existingData)
+	}
+	c.data = updated
+
+	for i, data := range dataList {
+		if skip[i] {
+			continue
+		}
+		if res, ok := found[data.Id]; ok {
+			results[i] = res
+		} else if outcome == outcomeRestored {
+			results[i] = itemError(data.Id, outcomeNotFound, "id %d is not deleted", data.Id)
+		} else {
+			results[i] = itemError(data.Id, outcomeNotFound, "id %d not found", data.Id)
+		}
+	}
+	return results
+}
+
+// 삭제된(툼스톤) 레코드를 되살림 -> [{"id": 3}, {"id": 5, "version": 4}]
+func handleTxUndelete(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	processTxData(w, r, c, "UNDELETE")
+}
+
+// 트랜잭션 요청 본문
+// -> {"operations": [{"op": "create", "data": {...}}, {"op": "delete", "data": {"id": 3}}]}
+type txOperation struct {
+	Op   string          `json:"op"`   // create, update, delete, restore
+	Data json.RawMessage `json:"data"` // 레코드 하나 (배치 경로와 같은 형식, unmarshalJSONRecord)
+}
+
+type txRequest struct {
+	Operations []txOperation `json:"operations"`
+}
+
+const outcomeAborted = "aborted" // 다른 작업이 실패해 트랜잭션 전체가 롤백됨
+
+// 트랜잭션 안에서 실패한 작업
+type opError struct {
+	Index   int // 실패한 작업의 위치
+	Outcome string
+	Message string
+	Fields  []fieldError // 검증에 실패한 경우 필드별 사유
+}
+
+func (e *opError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Message)
+}
+
+var operationKinds = map[string]pt.Operation_Kind{
+	"create":  pt.Operation_CREATE,
+	"update":  pt.Operation_UPDATE,
+	"delete":  pt.Operation_DELETE,
+	"restore": pt.Operation_RESTORE,
+}
+
+// 여러 작업(create, update, delete)을 하나의 트랜잭션으로 처리
+// -> 하나라도 실패하면 TxData는 그대로, 모두 성공하면 Rx에도 하나의 패키지로 전송
+func handleTxTransaction(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	var req txRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid transaction format: %v", err)
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid transaction format: %v", err))
+		return
+	}
+
+	// 레코드는 다른 쓰기 경로와 같은 방식으로 읽음 (표준 형식 또는 -legacy_json)
+	dataList := make([]sData, len(req.Operations))
+	for i, op := range req.Operations {
+		if len(op.Data) == 0 { // data가 없으면 ID 확인에서 실패
+			continue
+		}
+		data, err := unmarshalJSONRecord(op.Data)
+		if err != nil {
+			log.Printf("Invalid transaction format: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid transaction format (operation %d): %v", i, err))
+			return
+		}
+		dataList[i] = data
+	}
+
+	// JSON 작업 목록을 Protobuf 트랜잭션으로 변환
+	results := make([]itemResult, len(req.Operations))
+	tx := &pt.Transaction{}
+	expected := make([]int64, 0, len(req.Operations)) // 작업별 기대 버전 (0이면 확인 안 함)
+	var failed *opError
+	for i, op := range req.Operations {
+		kind, ok := operationKinds[op.Op]
+		switch {
+		case !ok:
+			failed = &opError{Index: i, Outcome: outcomeInvalid, Message: fmt.Sprintf("unknown op %q", op.Op)}
+		case dataList[i].Id < 0 || (dataList[i].Id == 0 && kind != pt.Operation_CREATE):
+			failed = &opError{Index: i, Outcome: outcomeInvalid, Message: "id must be a positive integer"}
+		case kind == pt.Operation_CREATE && dataList[i].Id > maxClientId:
+			failed = &opError{Index: i, Outcome: outcomeInvalid, Message: fmt.Sprintf("id must be at most %d", int64(maxClientId))}
+		}
+		if failed != nil {
+			break
+		}
+		data := toProtoData(dataList[i])
+		expiresAt, ferr := expiryFor(dataList[i], time.Now())
+		if ferr != nil {
+			failed = &opError{Index: i, Outcome: outcomeInvalid, Message: ferr.Field + ": " + ferr.Message, Fields: []fieldError{*ferr}}
+			break
+		}
+		data.ExpiresAt = expiresAt
+		tx.Operations = append(tx.Operations, &pt.Operation{
+			Kind: kind,
+			Data: data,
+		})
+		expected = append(expected, dataList[i].Version)
+	}
+
+	start := time.Now()
+	c.mu.Lock()
+	if !ifMatchOK(r, datasetETag(c.version)) {
+		version := c.version
+		c.mu.Unlock()
+		log.Printf("Transaction rejected: If-Match %s, current version %d", r.Header.Get("If-Match"), version)
+		writePreconditionFailed(w, version)
+		return
+	}
+	var before, after []*pt.Data
+	if failed == nil {
+		if failed = assignTransactionIds(c, tx); failed == nil {
+			before, after, _, failed = applyTransaction(&c.data, c.index, tx, expected)
+		}
+	}
+	if failed != nil {
+		version, count := c.version, len(liveData(c.data))
+		c.mu.Unlock()
+		// 롤백: 실패한 작업만 사유를 기록하고 나머지는 aborted
+		for i, data := range dataList {
+			results[i] = itemError(data.Id, outcomeAborted, "transaction rolled back")
+		}
+		results[failed.Index] = itemError(dataList[failed.Index].Id, failed.Outcome, "%s", failed.Message)
+		results[failed.Index].Fields = failed.Fields
+		status := http.StatusConflict
+		if failed.Outcome == outcomeInvalid {
+			status = http.StatusUnprocessableEntity
+		}
+		log.Printf("Transaction rolled back: %v", failed)
+		writeTransactionResults(w, status, version, count, results)
+		return
+	}
+	recordHistory(c, before, after, c.version+1, changedBy(r))
+	events := diffChanges(before, after, c.version+1)
+	count := len(liveData(c.data))
+	// 전체 데이터 대신 트랜잭션만 전송 -> Rx는 한 번에 적용
+	dataPackage := &pt.DataPackage{
+		Transaction: tx,
+		TotalCount:  int32(len(c.data)), // 적용 후의 전체 데이터 개수
+	}
+	version := commitAndReplicate(c, dataPackage, events)
+
+	outcomes := map[pt.Operation_Kind]string{
+		pt.Operation_CREATE:  outcomeCreated,
+		pt.Operation_UPDATE:  outcomeUpdated,
+		pt.Operation_DELETE:  outcomeDeleted,
+		pt.Operation_RESTORE: outcomeRestored,
+	}
+	for i, op := range tx.Operations {
+		results[i] = itemResult{Id: op.Data.Id, Outcome: outcomes[op.Kind]}
+	}
+	log.Printf("Transaction committed with %d operations.\n", len(tx.Operations))
+	fmt.Printf("-- Tx_Time elapsed for transaction: %d ms.\n", time.Since(start).Milliseconds())
+	writeTransactionResults(w, http.StatusOK, version, count, results)
+}
+
+// 트랜잭션 응답 (커밋, 롤백 공통) -> count는 처리 후 TxData의 개수
+func writeTransactionResults(w http.ResponseWriter, status int, version uint64, count int, results []itemResult) {
+	summary := make(map[string]int)
+	for _, res := range results {
+		summary[res.Outcome]++
+	}
+	writeResults(w, status, writeResponse{Version: version, Count: count, Summary: summary, Results: results, Transaction: true})
+}
+
+// ID가 0인 create 작업에 서버가 ID를 할당 (호출하는 쪽에서 c.mu를 잠근 상태여야 함)
+// -> 트랜잭션이 롤백되어도 할당한 ID는 다시 쓰지 않는다, 더 할당할 ID가 없으면 그 작업의 오류를 반환
+func assignTransactionIds(c *txCollection, tx *pt.Transaction) *opError {
+	for _, op := range tx.Operations {
+		if op.Kind == pt.Operation_CREATE && op.Data.Id > c.lastId {
+			c.lastId = op.Data.Id
+		}
+	}
+	for i, op := range tx.Operations {
+		if op.Kind == pt.Operation_CREATE && op.Data.Id == 0 {
+			if c.lastId == math.MaxInt64 {
+				return &opError{Index: i, Outcome: outcomeInvalid, Message: "no more ids can be assigned"}
+			}
+			c.lastId++
+			op.Data.Id = c.lastId
+		}
+	}
+	return nil
+}
+
+// 트랜잭션을 *data에 바로 적용 (Tx와 Rx가 함께 사용, index는 *data의 ID -> 위치이며 함께 갱신)
+// -> 바뀐 레코드만 다루므로 전체 데이터 크기와 상관없이 작업 수에 비례
+// -> 성공하면 바뀐 레코드의 적용 전후(없던 레코드, 제거된 레코드는 빠짐)와 되돌리는 함수를 반환, 실패하면 이미 되돌린 상태
+// expected가 nil이 아니면 (Tx) 작업마다 기대 버전을 확인하고 새 레코드 버전과 시각, 툼스톤을 만들어 op.Data에 기록,
+// Rx는 nil을 넘겨 Tx가 만든 레코드를 그대로 사용
+func applyTransaction(data *[]*pt.Data, index map[int64]int, tx *pt.Transaction, expected []int64) (before, after []*pt.Data, undo func(), failed *opError) {
+	now := timestamppb.Now()
+	work, size := *data, len(*data)
+	saved := make(map[int]*pt.Data) // 바꾼 위치 -> 원래 레코드 (되돌리기용)
+	original := make(map[int64]int) // 바꾼 ID -> 원래 위치 (없던 ID는 -1)
+	var touched []int64             // 바꾼 ID (처음 바꾼 순서)
+	removed := false                // 제거된 레코드가 있으면 마지막에 한 번에 정리하고 index를 다시 만듦
+	touch := func(id int64, pos int) {
+		if _, ok := original[id]; !ok {
+			prev, found := index[id]
+			if !found {
+				prev = -1
+			}
+			original[id] = prev
+			touched = append(touched, id)
+		}
+		if _, ok := saved[pos]; !ok && pos < size {
+			saved[pos] = work[pos]
+		}
+	}
+	undo = func() {
+		for pos, d := range saved {
+			work[pos] = d
+		}
+		*data = work[:size]
+		if removed {
+			clear(index)
+			maps.Copy(index, indexData(*data))
+			return
+		}
+		for id, pos := range original {
+			if pos < 0 {
+				delete(index, id)
+			} else {
+				index[id] = pos
+			}
+		}
+	}
+	rollback := func(err *opError) ([]*pt.Data, []*pt.Data, func(), *opError) {
+		undo()
+		return nil, nil, nil, err
+	}
+
+	for i, op := range tx.GetOperations() {
+		if op.GetData() == nil {
+			return rollback(&opError{Index: i, Outcome: outcomeInvalid, Message: "operation has no data"})
+		}
+		id, kind := op.Data.Id, op.GetKind()
+		pos, found := index[id]
+		deleted := found && work[pos].DeletedAt != nil // 툼스톤만 남은 레코드
+		live := found && !deleted
+		if kind == pt.Operation_CREATE || kind == pt.Operation_UPDATE {
+			if errs := validateRecord(op.GetData()); len(errs) > 0 {
+				return rollback(&opError{Index: i, Outcome: outcomeInvalid, Message: joinFieldErrors(errs), Fields: errs})
+			}
+		}
+		if expected != nil && found && expected[i] != 0 && expected[i] != work[pos].Version {
+			return rollback(&opError{Index: i, Outcome: outcomeConflict, Message: fmt.Sprintf("version mismatch: expected %d, current %d", expected[i], work[pos].Version)})
+		}
+		// Rx: 툼스톤보다 오래된 레코드가 늦게 도착하면 무시 -> 삭제된 ID가 되살아나지 않도록
+		if expected == nil && deleted && kind != pt.Operation_PURGE && op.Data.DeletedAt == nil && op.Data.Version <= work[pos].Version {
+			log.Printf("Ignoring stale %v for deleted id %d (version %d <= %d)", kind, id, op.Data.Version, work[pos].Version)
+			continue
+		}
+
+		switch kind {
+		case pt.Operation_CREATE:
+			if live {
+				return rollback(&opError{Index: i, Outcome: outcomeExists, Message: fmt.Sprintf("id %d already exists", id)})
+			}
+			if expected != nil {
+				op.Data.Version = 1
+				if deleted { // 삭제된 ID를 다시 만들면 버전을 이어서 증가
+					op.Data.Version = work[pos].Version + 1
+				}
+				op.Data.CreatedAt, op.Data.UpdatedAt = now, now
+			}
+			if deleted {
+				touch(id, pos)
+				work[pos] = op.Data
+			} else {
+				touch(id, len(work))
+				index[id] = len(work)
+				work = append(work, op.Data)
+			}
+		case pt.Operation_UPDATE:
+			if !live {
+				return rollback(&opError{Index: i, Outcome: outcomeNotFound, Message: fmt.Sprintf("id %d not found", id)})
+			}
+			if expected != nil {
+				op.Data.Version = work[pos].Version + 1
+				op.Data.CreatedAt, op.Data.UpdatedAt = work[pos].CreatedAt, now
+			}
+			touch(id, pos)
+			work[pos] = op.Data
+		case pt.Operation_DELETE:
+			if !live {
+				return rollback(&opError{Index: i, Outcome: outcomeNotFound, Message: fmt.Sprintf("id %d not found", id)})
+			}
+			if expected != nil {
+				op.Data = tombstone(work[pos], now)
+			}
+			touch(id, pos)
+			if op.Data.DeletedAt == nil { // 이전 버전 Tx는 ID만 보냄 -> 완전히 제거
+				work[pos], removed = nil, true
+				delete(index, id)
+			} else {
+				work[pos] = op.Data
+			}
+		case pt.Operation_PURGE:
+			if !found {
+				return rollback(&opError{Index: i, Outcome: outcomeNotFound, Message: fmt.Sprintf("id %d not found", id)})
+			}
+			touch(id, pos)
+			work[pos], removed = nil, true // 삭제 표시, 마지막에 한 번에 정리
+			delete(index, id)
+		case pt.Operation_RESTORE:
+			if !deleted {
+				return rollback(&opError{Index: i, Outcome: outcomeNotFound, Message: fmt.Sprintf("id %d is not deleted", id)})
+			}
+			if expected != nil {
+				op.Data = restored(work[pos], now)
+			}
+			touch(id, pos)
+			work[pos] = op.Data
+		default:
+			return rollback(&opError{Index: i, Outcome: outcomeInvalid, Message: fmt.Sprintf("unknown operation kind %v", kind)})
+		}
+	}
+
+	for _, id := range touched {
+		if pos := original[id]; pos >= 0 {
+			before = append(before, saved[pos])
+		}
+		if pos, ok := index[id]; ok {
+			after = append(after, work[pos])
+		}
+	}
+	*data = work
+	if removed { // 정리한 결과는 새 슬라이스에 담는다 (work는 되돌리기용으로 남김)
+		kept := make([]*pt.Data, 0, len(work))
+		for _, d := range work {
+			if d != nil {
+				kept = append(kept, d)
+			}
+		}
+		*data = kept
+		clear(index)
+		maps.Copy(index, indexData(kept))
+	}
+	return before, after, undo, nil
+}
+
+// 삭제 표시만 한 복사본 (GET에서는 숨기고, 보존 기간이 지나면 완전히 제거)
+func tombstone(d *pt.Data, now *timestamppb.Timestamp) *pt.Data {
+	tomb := proto.Clone(d).(*pt.Data)
+	tomb.Version++
+	tomb.UpdatedAt = now
+	tomb.DeletedAt = now
+	tomb.ExpiresAt = nil // 이미 삭제되었으므로 만료 시각은 의미 없음
+	return tomb
+}
+
+// 툼스톤을 다시 살린 복사본
+func restored(tomb *pt.Data, now *timestamppb.Timestamp) *pt.Data {
+	d := proto.Clone(tomb).(*pt.Data)
+	d.Version++
+	d.UpdatedAt = now
+	d.DeletedAt = nil
+	return d
+}
+
+// 툼스톤을 제외한 데이터 (GET 응답용)
+func liveData(dataList []*pt.Data) []*pt.Data {
+	live := make([]*pt.Data, 0, len(dataList))
+	for _, d := range dataList {
+		if d.DeletedAt == nil {
+			live = append(live, d)
+		}
+	}
+	return live
+}
+
+// Rx에 툼스톤이 있는 ID는, 받은 데이터의 버전이 툼스톤보다 높을 때만 반영
+// -> 늦게 도착한 이전 데이터가 삭제된 ID를 되살리지 못하도록
+func keepTombstones(current, incoming []*pt.Data) []*pt.Data {
+	tombs := make(map[int64]*pt.Data)
+	for _, d := range current {
+		if d.DeletedAt != nil {
+			tombs[d.Id] = d
+		}
+	}
+	if len(tombs) == 0 {
+		return incoming
+	}
+	for i, d := range incoming {
+		if tomb, ok := tombs[d.Id]; ok && d.DeletedAt == nil && d.Version <= tomb.Version {
+			log.Printf("Keeping tombstone for id %d (received version %d <= %d)", d.Id, d.Version, tomb.Version)
+			incoming[i] = tomb
+		}
+	}
+	return incoming
+}
+
+// 만료된 데이터를 주기적으로 삭제
+func expireTxDataEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, c := range txCollectionList() {
+			expireTxData(c, now)
+		}
+	}
+}
+
+// 만료 시각이 지난 데이터는 일반 삭제와 같이 툼스톤으로, 보존 기간이 지난 툼스톤은 완전히 제거
+// -> 하나의 트랜잭션으로 Rx에 전송
+func expireTxData(c *txCollection, now time.Time) {
+	c.mu.Lock()
+	tx := &pt.Transaction{}
+	for _, existingData := range c.data {
+		switch {
+		case existingData.DeletedAt != nil:
+			if !existingData.DeletedAt.AsTime().Add(tombstoneRetention).After(now) {
+				tx.Operations = append(tx.Operations, &pt.Operation{
+					Kind: pt.Operation_PURGE,
+					Data: &pt.Data{Id: existingData.Id},
+				})
+			}
+		case existingData.ExpiresAt != nil && !existingData.ExpiresAt.AsTime().After(now):
+			tx.Operations = append(tx.Operations, &pt.Operation{
+				Kind: pt.Operation_DELETE,
+				Data: &pt.Data{Id: existingData.Id},
+			})
+		}
+	}
+	if len(tx.Operations) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	before, after, _, failed := applyTransaction(&c.data, c.index, tx, make([]int64, len(tx.Operations)))
+	if failed != nil {
+		c.mu.Unlock()
+		log.Printf("Failed to expire data: %v", failed)
+		return
+	}
+	recordHistory(c, before, after, c.version+1, "expiry")
+	events := diffChanges(before, after, c.version+1)
+	dataPackage := &pt.DataPackage{
+		Transaction: tx,
+		TotalCount:  int32(len(c.data)),
+	}
+	log.Printf("Expiring or purging %d data, version %d.\n", len(tx.Operations), c.version+1)
+	commitAndReplicate(c, dataPackage, events)
+}
+
+// 커밋을 마무리하고 Rx로 전송 (Tx의 모든 쓰기 경로가 사용) -> 커밋된 버전을 반환
+// 호출하는 쪽에서 c.mu를 잠근 상태로 호출하면, 버전을 올리고 변경 피드에 알린 뒤 잠금을 풀고 dataPackage를 전송
+// (events는 c.version+1로 만든 이벤트, dataPackage의 버전과 컬렉션은 여기서 채움)
+func commitAndReplicate(c *txCollection, dataPackage *pt.DataPackage, events []changeEvent) uint64 {
+	c.version++
+	version := c.version
+	if version == 1 && c.name != defaultCollection && c.schema == nil {
+		log.Printf("Created collection %q", c.name)
+	}
+	c.feed.publish(version, events)
+	dataPackage.Version, dataPackage.Collection = version, c.name
+	c.replication.Lock() // Tx 잠금을 풀기 전에 잡아서, 다음 커밋이 먼저 전송되지 않도록
+	if c.resync && dataPackage.Transaction != nil {
+		// 앞선 전송이 실패해 Rx가 이전 버전에 머물러 있을 수 있음 -> 변경분 대신 전체 데이터로 다시 맞춤
+		snapshot := append([]*pt.Data(nil), c.data...)
+		dataPackage.Transaction, dataPackage.DataList, dataPackage.TotalCount = nil, snapshot, int32(len(snapshot))
+		log.Printf("Sending full data of %q (version %d) to resync Rx.", c.name, version)
+	}
+	c.mu.Unlock()
+
+	err := sendToRx(dataPackage)
+	if err != nil {
+		log.Printf("Error sending data to Rx server: %v", err)
+	}
+	c.resync = err != nil
+	c.replication.Unlock()
+	return version
+}
+
+// 레코드의 한 버전 (Tx 커밋마다 바뀐 레코드만 기록)
+type historyEntry struct {
+	DatasetVersion uint64          `json:"dataset_version"` // 이 변경이 커밋된 데이터셋 버전
+	At             time.Time       `json:"at"`
+	ChangedBy      string          `json:"changed_by"`     // X-Client-Id 헤더, 없으면 클라이언트 주소 (만료는 "expiry")
+	Change         string          `json:"change"`         // created, updated, deleted, restored
+	Data           json.RawMessage `json:"data,omitempty"` // 응답할 때 record로 채움
+	record         *pt.Data        // 시점 조회용 원본
+}
+
+func changedBy(r *http.Request) string {
+	if client := r.Header.Get("X-Client-Id"); client != "" {
+		return client
+	}
+	return r.RemoteAddr
+}
+
+// 커밋 전후의 데이터를 비교해 바뀐 레코드를 이력에 추가
+// -> 레코드는 바뀔 때마다 새로 만들어지므로 포인터만 비교하면 됨
+// 호출하는 쪽에서 c.mu를 잠근 상태여야 함
+func recordHistory(c *txCollection, before, after []*pt.Data, version uint64, by string) {
+	now := time.Now()
+	previous := make(map[int64]*pt.Data, len(before))
+	for _, d := range before {
+		previous[d.Id] = d
+	}
+	for _, d := range after {
+		prev, ok := previous[d.Id]
+		delete(previous, d.Id)
+		if ok && prev == d {
+			continue
+		}
+		change := "updated"
+		switch {
+		case !ok:
+			change = "created"
+		case d.DeletedAt != nil && prev.DeletedAt == nil:
+			change = "deleted"
+		case d.DeletedAt == nil && prev.DeletedAt != nil:
+			change = "restored"
+			if !d.CreatedAt.AsTime().Equal(prev.CreatedAt.AsTime()) { // 삭제된 ID로 새로 만든 경우
+				change = "created"
+			}
+		}
+		appendHistory(c, d.Id, historyEntry{DatasetVersion: version, At: now, ChangedBy: by, Change: change, record: d})
+	}
+	for id := range previous { // 완전히 제거된 레코드 -> 이력도 지움 (남겨 두면 제거된 ID마다 계속 쌓임)
+		forgetHistory(c, id, version)
+	}
+}
+
+// 제거된 레코드의 이력을 지우고, 이 레코드 없이 복원할 수 없게 된 시점만큼 historyFloor를 올림
+// -> 툼스톤이 된 뒤의 시점에는 어차피 보이지 않으므로 삭제된 버전부터는 그대로 복원 가능
+func forgetHistory(c *txCollection, id int64, version uint64) {
+	entries, ok := c.history[id]
+	if !ok {
+		return
+	}
+	floor := version
+	if last := entries[len(entries)-1]; last.record.DeletedAt != nil {
+		floor = last.DatasetVersion
+	}
+	if floor > c.historyFloor {
+		c.historyFloor = floor
+	}
+	delete(c.history, id)
+}
+
+func appendHistory(c *txCollection, id int64, entry historyEntry) {
+	entries := append(c.history[id], entry)
+	if over := len(entries) - historyLimit; over > 0 {
+		// 잘려 나간 이력 이후 첫 버전부터만 이 레코드를 복원할 수 있음
+		if floor := entries[over].DatasetVersion; floor > c.historyFloor {
+			c.historyFloor = floor
+		}
+		entries = append([]historyEntry(nil), entries[over:]...)
+	}
+	c.history[id] = entries
+}
+
+// version 시점의 데이터셋 (삭제된 레코드 제외, ID 순)
+// 호출하는 쪽에서 c.mu를 잠근 상태여야 함
+func datasetAt(c *txCollection, version uint64) []*pt.Data {
+	var dataList []*pt.Data
+	for _, entries := range c.history {
+		var rec *pt.Data
+		for _, entry := range entries {
+			if entry.DatasetVersion > version {
+				break
+			}
+			rec = entry.record
+		}
+		if rec != nil && rec.DeletedAt == nil {
+			dataList = append(dataList, rec)
+		}
+	}
+	slices.SortFunc(dataList, func(a, b *pt.Data) int { return cmp.Compare(a.Id, b.Id) })
+	return dataList
+}
+
+// at 시각에 마지막으로 커밋된 데이터셋 버전
+// 호출하는 쪽에서 c.mu를 잠근 상태여야 함
+func versionAt(c *txCollection, at time.Time) uint64 {
+	var version uint64
+	for _, entries := range c.history {
+		for _, entry := range entries {
+			if entry.At.After(at) {
+				break
+			}
+			version = max(version, entry.DatasetVersion)
+		}
+	}
+	return version
+}
+
+// 레코드 하나의 변경 이력 -> GET /history?id=3
+func handleTxHistory(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "id must be a positive integer")
+		return
+	}
+
+	c.mu.RLock()
+	entries := append([]historyEntry(nil), c.history[id]...)
+	c.mu.RUnlock()
+	if len(entries) == 0 {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no history for id %d", id))
+		return
+	}
+	for i := range entries {
+		if entries[i].Data, err = marshalJSONRecord(entries[i].record); err != nil {
+			writeInternalError(w, fmt.Errorf("failed to marshal history: %w", err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "versions": entries})
+}
+
+// 과거 시점의 전체 데이터 -> GET /snapshot?version=5 또는 /snapshot?at=2024-01-02T15:04:05Z
+func handleTxSnapshot(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	dataList, version, status, err := snapshotAt(c, r.URL.Query())
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	responseData, err := marshalJSONList(dataList)
+	if err != nil {
+		writeInternalError(w, fmt.Errorf("failed to marshal snapshot: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", datasetETag(version))
+	w.Write(responseData)
+}
+
+// ?version= 또는 ?at= 시점의 데이터 (/snapshot과 /export가 함께 사용)
+// -> 실패하면 응답할 상태 코드와 사유 (현재보다 새 버전은 404, 이력이 남아 있지 않으면 410)
+func snapshotAt(c *txCollection, query url.Values) ([]*pt.Data, uint64, int, error) {
+	var at time.Time
+	var version uint64
+	var err error
+	switch {
+	case query.Has("version") && query.Has("at"):
+		err = fmt.Errorf("version and at cannot both be set")
+	case query.Has("version"):
+		version, err = strconv.ParseUint(query.Get("version"), 10, 64)
+	case query.Has("at"):
+		at, err = time.Parse(time.RFC3339Nano, query.Get("at"))
+	default:
+		err = fmt.Errorf("version or at is required")
+	}
+	if err != nil {
+		return nil, 0, http.StatusBadRequest, err
+	}
+
+	c.mu.RLock()
+	if query.Has("at") {
+		version = versionAt(c, at)
+	}
+	current, floor := c.version, c.historyFloor
+	var dataList []*pt.Data
+	if version <= current && version >= floor {
+		dataList = datasetAt(c, version)
+	}
+	c.mu.RUnlock()
+
+	switch {
+	case version > current:
+		return nil, 0, http.StatusNotFound, fmt.Errorf("version %d is newer than current version %d", version, current)
+	case version < floor:
+		return nil, 0, http.StatusGone, fmt.Errorf("history before version %d is no longer kept", floor)
+	}
+	return dataList, version, 0, nil
+}
+
+// 변경 피드 (GET /changes) -> Server-Sent Events로 레코드 변경을 실시간 전송 ----------------------
+// event: insert|update|delete, data: {"version":..,"op":..,"id":..,"record":{...}}
+// -> 한 커밋(버전)의 이벤트 중 마지막 이벤트에만 id: <버전>을 붙임
+// -> 연결이 끊기면 클라이언트는 Last-Event-ID (또는 ?since=)로 그 버전 이후부터 이어받음 (커밋 중간에 끊겨도 커밋 단위로 다시 받음)
+// -> 피드에 남아 있지 않은 버전이면 event: reset -> 클라이언트는 전체 데이터를 다시 조회한 뒤 이어받음
+
+const (
+	opInsert = "insert" // 새 레코드 (삭제된 ID를 다시 만들거나 복구한 경우 포함)
+	opUpdate = "update"
+	opDelete = "delete" // record는 툼스톤 (deleted_at 포함)
+	opPurge  = "purge"  // 보존 기간이 지난 툼스톤 제거 -> MQTT 브리지만 사용 (/changes와 웹훅에는 보내지 않음)
+)
+
+type changeEvent struct {
+	Collection string          `json:"collection,omitempty"` // 웹훅에서만 (/changes는 경로로 구분)
+	Version    uint64          `json:"version"`
+	Op         string          `json:"op"`
+	Id         int64           `json:"id"`
+	Record     json.RawMessage `json:"record"` // 전송할 때 record로 채움
+	record     *pt.Data
+}
+
+// 전송할 JSON (레코드는 marshalJSONRecord 형식)
+func (e changeEvent) marshal() ([]byte, error) {
+	record, err := marshalJSONRecord(e.record)
+	if err != nil {
+		return nil, err
+	}
+	e.Record = record
+	return json.Marshal(e)
+}
+
+// 컬렉션의 최근 변경 이벤트 (name만 채워 사용, 호출하는 쪽에서 컬렉션의 mu를 잠근 상태로 publish)
+type changeFeed struct {
+	name    string // 컬렉션 이름
+	mu      sync.Mutex
+	events  []changeEvent // 오래된 순서, 최대 feedLimit개
+	version uint64        // 마지막으로 알린 버전
+	floor   uint64        // 이 버전 이후부터만 이어받을 수 있음 (앞의 이벤트는 잘려 나감)
+	changed chan struct{} // 다음 publish에서 닫힘 -> 기다리는 연결을 모두 깨움
+}
+
+func (f *changeFeed) publish(version uint64, events []changeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, events...)
+	if over := len(f.events) - feedLimit; over > 0 {
+		f.floor = f.events[over-1].Version
+		f.events = append([]changeEvent(nil), f.events[over:]...)
+	}
+	f.version = version
+	if f.changed != nil {
+		close(f.changed)
+		f.changed = nil
+	}
+	if feedListener != nil {
+		feedListener(f)
+	}
+}
+
+// last 이후의 이벤트, 현재 버전, 다음 변경 때 닫히는 채널
+// last부터 이어받을 수 없으면 (잘려 나갔거나 현재보다 새 버전) false
+func (f *changeFeed) since(last uint64) ([]changeEvent, uint64, <-chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.changed == nil {
+		f.changed = make(chan struct{})
+	}
+	if last < f.floor || last > f.version {
+		return nil, f.version, f.changed, false
+	}
+	i, _ := slices.BinarySearchFunc(f.events, last+1, func(e changeEvent, v uint64) int { return cmp.Compare(e.Version, v) })
+	return slices.Clone(f.events[i:]), f.version, f.changed, true
+}
+
+// 커밋 전후의 데이터를 레코드 버전으로 비교해 변경 이벤트를 만듦
+// (Rx는 패키지마다 레코드를 새로 받으므로 포인터가 아니라 버전으로 비교)
+func diffChanges(before, after []*pt.Data, version uint64) []changeEvent {
+	previous := make(map[int64]*pt.Data, len(before))
+	for _, d := range before {
+		previous[d.Id] = d
+	}
+	var events []changeEvent
+	for _, d := range after {
+		prev, ok := previous[d.Id]
+		delete(previous, d.Id)
+		op := ""
+		switch {
+		case !ok || prev.DeletedAt != nil:
+			if d.DeletedAt == nil {
+				op = opInsert
+			}
+		case prev.Version != d.Version:
+			op = opUpdate
+			if d.DeletedAt != nil {
+				op = opDelete
+			}
+		}
+		if op != "" {
+			events = append(events, changeEvent{Version: version, Op: op, Id: d.Id, record: d})
+		}
+	}
+	var removed []changeEvent // 툼스톤 없이 제거된 레코드 (이전 버전 Tx)는 delete, 툼스톤이 정리된 것은 purge
+	for id, prev := range previous {
+		op := opDelete
+		if prev.DeletedAt != nil {
+			op = opPurge
+		}
+		removed = append(removed, changeEvent{Version: version, Op: op, Id: id, record: prev})
+	}
+	slices.SortFunc(removed, func(a, b changeEvent) int { return cmp.Compare(a.Id, b.Id) })
+	return append(events, removed...)
+}
+
+func handleTxChanges(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	serveChangeFeed(w, r, c.name, &c.feed)
+}
+
+func handleRxChanges(w http.ResponseWriter, r *http.Request) {
+	name, err := collectionName(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := rxCollectionFor(name, false)
+	if c == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q not found", name))
+		return
+	}
+	c.mu.RLock()
+	dynamic := c.schema != nil
+	c.mu.RUnlock()
+	if dynamic {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("collection %q has a dynamic schema; /changes is not supported", name))
+		return
+	}
+	serveChangeFeed(w, r, name, &c.feed)
+}
+
+// 롱 폴링 (GET /data?min_version=N&wait=30s) -> Tx 쓰기 응답의 version이 Rx에 반영될 때까지 기다렸다가 응답
+// -> 테스트에서 Tx에 쓴 내용이 Rx에 보이는지 sleep 없이 확인 (wait 안에 반영되지 않으면 504)
+
+const maxVersionWait = time.Minute
+
+// ?min_version=이 없거나 이미 반영되었으면 바로 true, 실패하면 오류를 응답하고 false
+func waitForVersion(w http.ResponseWriter, r *http.Request, c *rxCollection) bool {
+	query := r.URL.Query()
+	if !query.Has("min_version") {
+		return true
+	}
+	minVersion, err := strconv.ParseUint(query.Get("min_version"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "min_version must be a non-negative integer")
+		return false
+	}
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 || wait > maxVersionWait {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("wait must be a duration between 0s and %s", maxVersionWait))
+			return false
+		}
+	}
+	start := time.Now()
+	current, ok := c.feed.waitFor(r.Context(), minVersion, wait)
+	if !ok {
+		writeAPIError(w, http.StatusGatewayTimeout, "", fmt.Sprintf("version %d was not applied within %s", minVersion, wait),
+			map[string]uint64{"min_version": minVersion, "current_version": current})
+		return false
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		log.Printf("Waited %d ms for version %d", waited.Milliseconds(), minVersion)
+	}
+	return true
+}
+
+// 피드의 버전이 version 이상이 될 때까지 기다림 (timeout이 지나거나 요청이 취소되면 false) -> 마지막으로 본 버전도 반환
+func (f *changeFeed) waitFor(ctx context.Context, version uint64, timeout time.Duration) (uint64, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		_, current, changed, _ := f.since(version)
+		if current >= version {
+			return current, true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return current, false
+		case <-ctx.Done():
+			return current, false
+		}
+	}
+}
+
+const feedHeartbeat = 15 * time.Second // 프록시가 유휴 연결을 끊지 않도록 주석 줄 전송
+
+func serveChangeFeed(w http.ResponseWriter, r *http.Request, name string, feed *changeFeed) {
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = r.URL.Query().Get("since") // EventSource는 첫 연결에 헤더를 붙일 수 없음
+	}
+	_, last, _, _ := feed.since(0)
+	if lastId != "" { // 없으면 지금부터의 변경만
+		var err error
+		if last, err = strconv.ParseUint(lastId, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid Last-Event-ID %q", lastId))
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx 버퍼링 끄기
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	log.Printf("Change feed of %q opened from version %d (%s)", name, last, r.RemoteAddr)
+	defer log.Printf("Change feed of %q closed (%s)", name, r.RemoteAddr)
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		events, current, changed, ok := feed.since(last)
+		if !ok {
+			fmt.Fprintf(w, "event: reset\nid: %d\ndata: {\"version\":%d}\n\n", current, current)
+		}
+		events = slices.DeleteFunc(events, func(e changeEvent) bool { return e.Op == opPurge })
+		for i, e := range events {
+			data, err := e.marshal()
+			if err != nil {
+				log.Printf("Failed to marshal change event: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "event: %s\n", e.Op)
+			if i == len(events)-1 || events[i+1].Version != e.Version {
+				fmt.Fprintf(w, "id: %d\n", e.Version)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		last = current
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// 웹훅 (/webhooks) -> 컬렉션의 변경 이벤트를 구독한 URL로 하나씩 POST --------------------------------
+// 본문은 /changes의 data와 같은 이벤트, X-Webhook-Signature: sha256=HMAC-SHA256(secret, "<X-Webhook-Timestamp>.<본문>")
+// -> 구독마다 고루틴 하나가 피드를 따라가며 순서대로 전송, 실패하면 백오프로 재시도하고 끝내 실패하면 dead letter로 남기고 다음 이벤트로
+
+const (
+	webhookAttempts       = 5 // 첫 시도 포함
+	webhookInitialBackoff = time.Second
+	webhookMaxBackoff     = 30 * time.Second
+	webhookTimeout        = 10 * time.Second
+	maxDeadLetters        = 1000 // 메모리에 보관하는 개수 (-webhook_dead_letters 파일에는 모두 기록)
+)
+
+type webhook struct {
+	Id         string    `json:"id"`
+	URL        string    `json:"url"`
+	Collection string    `json:"collection"`
+	Events     []string  `json:"events,omitempty"` // 비어 있으면 insert, update, delete 모두
+	Secret     string    `json:"secret,omitempty"` // 만들 때 응답에만 포함 (지정하지 않으면 서버가 생성)
+	CreatedAt  time.Time `json:"created_at"`
+
+	mu    sync.Mutex
+	stats webhookStats
+	stop  chan struct{} // 삭제하면 닫힘 -> 전송 고루틴 종료
+}
+
+// 목록에 함께 보여주는 전송 현황
+type webhookStats struct {
+	Version     uint64     `json:"version"` // 마지막으로 처리한 (성공 또는 dead letter) 버전
+	Delivered   int        `json:"delivered"`
+	Failed      int        `json:"failed"` // dead letter로 보낸 이벤트 수
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type deadLetter struct {
+	Webhook  string          `json:"webhook"`
+	URL      string          `json:"url"`
+	At       time.Time       `json:"at"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Event    json.RawMessage `json:"event"` // 보내려던 본문 (피드가 잘려 이벤트를 놓친 경우는 null)
+}
+
+var (
+	webhooks       = map[string]*webhook{}
+	deadLetters    []deadLetter
+	webhooksMutex  sync.Mutex
+	deadLetterFile string                                  // -webhook_dead_letters: dead letter를 한 줄씩 덧붙이는 파일
+	webhookToken   string                                  // -webhook_token: /webhooks 관리에 필요한 토큰 (비어 있으면 웹훅 사용 불가)
+	webhookFeed    func(collection string) *changeFeed     // Tx와 Rx가 각자 컬렉션의 피드를 찾는 방법
+	webhookClient  = &http.Client{Timeout: webhookTimeout} // 리다이렉트도 따라감
+)
+
+func registerWebhookRoutes() {
+	http.HandleFunc("GET /webhooks", withWebhookToken(handleListWebhooks))
+	http.HandleFunc("POST /webhooks", withWebhookToken(handleAddWebhook))
+	http.HandleFunc("DELETE /webhooks/{id}", withWebhookToken(handleRemoveWebhook))
+	http.HandleFunc("GET /webhooks/dead-letters", withWebhookToken(handleDeadLetters))
+}
+
+// 웹훅은 서버가 임의의 URL로 요청을 보내게 하고 서명 secret도 돌려주므로 -webhook_token을 아는 클라이언트만 관리
+func withWebhookToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if webhookToken == "" {
+			writeJSONError(w, http.StatusForbidden, "webhooks are disabled (start the server with -webhook_token)")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(webhookToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid webhook token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// 목록 응답 항목 (secret은 보여주지 않음)
+type webhookInfo struct {
+	Id         string       `json:"id"`
+	URL        string       `json:"url"`
+	Collection string       `json:"collection"`
+	Events     []string     `json:"events,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Stats      webhookStats `json:"stats"`
+}
+
+func handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooksMutex.Lock()
+	list := make([]webhookInfo, 0, len(webhooks))
+	for _, hook := range webhooks {
+		hook.mu.Lock()
+		list = append(list, webhookInfo{Id: hook.Id, URL: hook.URL, Collection: hook.Collection, Events: hook.Events, CreatedAt: hook.CreatedAt, Stats: hook.stats})
+		hook.mu.Unlock()
+	}
+	webhooksMutex.Unlock()
+	slices.SortFunc(list, func(a, b webhookInfo) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// POST /webhooks {"url": ..., "collection": ..., "events": [...], "secret": ...} -> 201 (secret은 이 응답에서만 보임)
+func handleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	var hook webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid webhook format: %v", err))
+		return
+	}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeJSONError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+	for _, op := range hook.Events {
+		if op != opInsert && op != opUpdate && op != opDelete {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q (insert, update, delete)", op))
+			return
+		}
+	}
+	if hook.Collection == "" {
+		hook.Collection = defaultCollection
+	}
+	feed := webhookFeed(hook.Collection)
+	if feed == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q not found", hook.Collection))
+		return
+	}
+	if hook.Secret == "" {
+		hook.Secret = newRequestId() + newRequestId()
+	}
+	hook.Id = "wh_" + newRequestId()
+	hook.CreatedAt = time.Now()
+	hook.stop = make(chan struct{})
+	_, hook.stats.Version, _, _ = feed.since(0) // 지금 이후의 변경만 전송
+
+	webhooksMutex.Lock()
+	webhooks[hook.Id] = &hook
+	webhooksMutex.Unlock()
+	go runWebhook(&hook, feed)
+	log.Printf("Added webhook %s for %q -> %s", hook.Id, hook.Collection, hook.URL)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/webhooks/"+hook.Id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&hook)
+}
+
+func handleRemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	webhooksMutex.Lock()
+	hook, ok := webhooks[id]
+	delete(webhooks, id)
+	webhooksMutex.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("webhook %q not found", id))
+		return
+	}
+	close(hook.stop)
+	log.Printf("Removed webhook %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /webhooks/dead-letters?webhook=wh_... -> 최근 것부터
+func handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("webhook")
+	webhooksMutex.Lock()
+	list := make([]deadLetter, 0)
+	for i := len(deadLetters) - 1; i >= 0; i-- {
+		if id == "" || deadLetters[i].Webhook == id {
+			list = append(list, deadLetters[i])
+		}
+	}
+	webhooksMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// 구독 하나의 전송 루프 -> 삭제될 때까지 피드를 따라가며 이벤트를 순서대로 전송
+func runWebhook(hook *webhook, feed *changeFeed) {
+	hook.mu.Lock()
+	last := hook.stats.Version
+	hook.mu.Unlock()
+	for {
+		events, current, changed, ok := feed.since(last)
+		if !ok { // 전송이 밀려 피드에서 잘려 나감 -> 놓친 범위를 dead letter로 남기고 현재부터 계속
+			addDeadLetter(hook, nil, 0, fmt.Errorf("events after version %d were dropped from the change feed before delivery", last))
+		}
+		for _, e := range events {
+			if e.Op == opPurge || (len(hook.Events) > 0 && !slices.Contains(hook.Events, e.Op)) {
+				continue
+			}
+			e.Collection = hook.Collection
+			body, err := e.marshal()
+			attempts := 0
+			if err == nil {
+				attempts, err = deliverWebhook(hook, e, body)
+			}
+			select {
+			case <-hook.stop:
+				return
+			default:
+			}
+			hook.mu.Lock()
+			if err == nil {
+				hook.stats.Delivered++
+			}
+			hook.stats.Version = e.Version
+			hook.mu.Unlock()
+			if err != nil {
+				addDeadLetter(hook, body, attempts, err)
+			}
+		}
+		last = current
+		hook.mu.Lock()
+		hook.stats.Version = current
+		hook.mu.Unlock()
+		select {
+		case <-changed:
+		case <-hook.stop:
+			return
+		}
+	}
+}
+
+// 성공 (2xx)할 때까지 백오프로 재시도 -> 4xx (408, 429 제외)는 다시 보내도 같으므로 바로 실패
+func deliverWebhook(hook *webhook, e changeEvent, body []byte) (int, error) {
+	backoff := webhookInitialBackoff
+	delivery := newRequestId()
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		if retry, err = postWebhook(hook, e, body, delivery); err == nil {
+			return attempt, nil
+		}
+		if !retry || attempt == webhookAttempts {
+			return attempt, err
+		}
+		log.Printf("Webhook %s delivery %s failed (attempt %d): %v, retrying in %s", hook.Id, delivery, attempt, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-hook.stop:
+			return attempt, err
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+}
+
+func postWebhook(hook *webhook, e changeEvent, body []byte, delivery string) (bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "prototest-webhook")
+	req.Header.Set("X-Webhook-Id", hook.Id)
+	req.Header.Set("X-Webhook-Delivery", delivery) // 재시도해도 같은 값 -> 받는 쪽에서 중복 제거
+	req.Header.Set("X-Webhook-Event", e.Op)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // 연결 재사용
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("endpoint returned %s", resp.Status)
+}
+
+func addDeadLetter(hook *webhook, body []byte, attempts int, err error) {
+	now := time.Now()
+	letter := deadLetter{Webhook: hook.Id, URL: hook.URL, At: now, Attempts: attempts, Error: err.Error(), Event: body}
+	log.Printf("Webhook %s gave up after %d attempts: %v", hook.Id, attempts, err)
+
+	hook.mu.Lock()
+	hook.stats.Failed++
+	hook.stats.LastError, hook.stats.LastErrorAt = err.Error(), &now
+	hook.mu.Unlock()
+
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	deadLetters = append(deadLetters, letter)
+	if over := len(deadLetters) - maxDeadLetters; over > 0 {
+		deadLetters = append([]deadLetter(nil), deadLetters[over:]...)
+	}
+	if deadLetterFile != "" {
+		line, _ := json.Marshal(letter)
+		f, err := os.OpenFile(deadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Printf("Failed to write dead letter: %v", err)
+			return
+		}
+		defer f.Close()
+		f.Write(append(line, '\n'))
+	}
+}
+
+// MQTT 브리지 (-mqtt_broker) -> 컬렉션의 변경 이벤트를 MQTT 3.1.1 브로커로 발행 --------------------------
+// 토픽: prototest/data/{id} (기본 컬렉션), prototest/collections/{name}/data/{id}
+// 페이로드: -mqtt_format=json이면 /changes의 data와 같은 이벤트, protobuf면 레코드 (pt.Data, 삭제는 deleted_at이 있는 툼스톤)
+// -> -mqtt_retain이면 토픽마다 마지막 값이 브로커에 남음 (새 구독자가 바로 현재 레코드를 받음)
+//    툼스톤이 보존 기간이 지나 제거되면 빈 보관 메시지를 보내 남은 값을 지움
+// -> 연결이 끊기면 백오프로 다시 연결하고, PUBACK을 받지 못한 QoS 1 메시지는 DUP로 다시 보냄
+// -> 브로커가 없는 환경에서는 -mode=broker로 최소 브로커를 띄워 확인
+
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+const (
+	mqttKeepAlive      = 30 * time.Second
+	mqttInitialBackoff = time.Second
+	mqttMaxBackoff     = 30 * time.Second
+	mqttQueueSize      = 1024             // 연결이 끊긴 동안 쌓아 두는 메시지 수 (넘치면 피드를 따라가는 쪽이 기다림)
+	mqttMaxInflight    = 1024             // PUBACK을 기다리는 QoS 1 메시지 수 (가득 차면 확인을 받을 때까지 큐에서 꺼내지 않음)
+	mqttMaxPacket      = 64 * 1024 * 1024 // 받는 패킷의 남은 길이 상한 (넘으면 읽지 않고 연결을 끊음)
+)
+
+var (
+	mqttBrokerAddr  string // -mqtt_broker: 비어 있으면 MQTT로 발행하지 않음
+	mqttClientId    string
+	mqttQoS         int
+	mqttRetain      bool
+	mqttFormat      string
+	mqttTopicPrefix string
+	feedListener    func(f *changeFeed) // publish 뒤 호출 (피드의 mu를 잠근 상태 -> 피드를 다시 읽으면 안 됨)
+)
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+	dup     bool
+	id      uint16        // QoS 1에서만
+	acked   chan struct{} // 있으면 QoS 1은 PUBACK을 받을 때, QoS 0은 보낸 뒤 닫힘
+}
+
+// 고정 헤더 + 남은 길이 (7비트씩, 최대 4바이트)
+func mqttPacket(header byte, parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	packet := []byte{header}
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	for _, p := range parts {
+		packet = append(packet, p...)
+	}
+	return packet
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+		n += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if n > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("packet too large (%d bytes, limit %d)", n, mqttMaxPacket)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// UTF-8 문자열 (2바이트 길이 + 내용)
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, fmt.Errorf("truncated string")
+	}
+	n := 2 + int(binary.BigEndian.Uint16(b))
+	return string(b[2:n]), b[n:], nil
+}
+
+func mqttPublishPacket(m mqttMessage) []byte {
+	header := byte(mqttPublish<<4) | m.qos<<1
+	if m.dup {
+		header |= 0x08
+	}
+	if m.retain {
+		header |= 0x01
+	}
+	variable := mqttString(m.topic)
+	if m.qos > 0 {
+		variable = binary.BigEndian.AppendUint16(variable, m.id)
+	}
+	return mqttPacket(header, variable, m.payload)
+}
+
+func parseMQTTPublish(header byte, body []byte) (mqttMessage, error) {
+	m := mqttMessage{qos: header >> 1 & 0x03, retain: header&0x01 != 0, dup: header&0x08 != 0}
+	if m.qos > 1 {
+		return m, fmt.Errorf("QoS %d is not supported", m.qos)
+	}
+	topic, rest, err := readMQTTString(body)
+	if err != nil {
+		return m, err
+	}
+	m.topic = topic
+	if m.qos > 0 {
+		if len(rest) < 2 {
+			return m, fmt.Errorf("missing packet identifier")
+		}
+		m.id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	m.payload = rest
+	return m, nil
+}
+
+// 레코드 하나의 토픽 (컬렉션 경로와 같은 모양)
+func mqttTopic(collection string, id int64) string {
+	if collection == defaultCollection {
+		return fmt.Sprintf("%s/data/%d", mqttTopicPrefix, id)
+	}
+	return fmt.Sprintf("%s/collections/%s/data/%d", mqttTopicPrefix, collection, id)
+}
+
+// 브로커 하나에 연결을 유지하며 큐의 메시지를 순서대로 발행
+type mqttClient struct {
+	addr     string
+	clientId string
+	password string // 있으면 CONNECT에 사용자 이름 (클라이언트 ID)과 함께 보냄
+	queue    chan mqttMessage
+
+	mu       sync.Mutex
+	inflight []mqttMessage // PUBACK을 기다리는 QoS 1 메시지 (보낸 순서, 최대 mqttMaxInflight)
+	room     chan struct{} // inflight가 가득 찬 뒤 PUBACK으로 자리가 나면 알림
+	nextId   uint16
+}
+
+func newMQTTClient(addr, clientId string) *mqttClient {
+	return &mqttClient{addr: addr, clientId: clientId, queue: make(chan mqttMessage, mqttQueueSize), room: make(chan struct{}, 1)}
+}
+
+// 큐가 가득 차면 (브로커에 연결하지 못한 채 계속 쌓이면) 자리가 날 때까지 기다림
+func (c *mqttClient) publish(m mqttMessage) {
+	c.queue <- m
+}
+
+func (c *mqttClient) run() {
+	backoff := mqttInitialBackoff
+	for {
+		conn, r, err := c.connect()
+		if err != nil {
+			log.Printf("Failed to connect to MQTT broker %s: %v, retrying in %s", c.addr, err, backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, mqttMaxBackoff)
+			continue
+		}
+		backoff = mqttInitialBackoff
+		log.Printf("Connected to MQTT broker %s as %q", c.addr, c.clientId)
+		err = c.serve(conn, r)
+		log.Printf("MQTT connection to %s lost: %v, reconnecting", c.addr, err)
+	}
+}
+
+// CONNECT (clean session) -> CONNACK
+func (c *mqttClient) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	flags := byte(0x02) // clean session
+	payload := mqttString(c.clientId)
+	if c.password != "" { // 3.1.1은 사용자 이름 없이 비밀번호만 보낼 수 없음
+		flags |= 0x80 | 0x40
+		payload = append(payload, mqttString(c.clientId)...)
+		payload = append(payload, mqttString(c.password)...)
+	}
+	variable := mqttString("MQTT")
+	variable = append(variable, 4, flags) // 프로토콜 레벨 4 (3.1.1)
+	variable = binary.BigEndian.AppendUint16(variable, uint16(mqttKeepAlive/time.Second))
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(mqttConnect<<4, variable, payload)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	header, body, err := readMQTTPacket(r)
+	switch {
+	case err != nil:
+	case header>>4 != mqttConnack || len(body) != 2:
+		err = fmt.Errorf("expected CONNACK, got packet type %d", header>>4)
+	case body[1] != 0:
+		err = fmt.Errorf("connection refused (return code %d)", body[1])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+// 연결이 끊길 때까지 발행 -> 쓰기는 이 고루틴에서만, 읽기 (PUBACK, PINGRESP)는 별도 고루틴
+func (c *mqttClient) serve(conn net.Conn, r *bufio.Reader) error {
+	defer conn.Close()
+	write := func(packet []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(packet)
+		return err
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2)) // PINGRESP도 오지 않으면 끊긴 것으로 봄
+			header, body, err := readMQTTPacket(r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if header>>4 == mqttPuback && len(body) == 2 {
+				c.acknowledge(binary.BigEndian.Uint16(body))
+			}
+		}
+	}()
+
+	// 이전 연결에서 확인받지 못한 메시지부터
+	c.mu.Lock()
+	pending := slices.Clone(c.inflight)
+	c.mu.Unlock()
+	for _, m := range pending {
+		m.dup = true
+		if err := write(mqttPublishPacket(m)); err != nil {
+			return err
+		}
+	}
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		queue := c.queue
+		if c.full() { // 자리가 날 때까지 꺼내지 않음 -> 큐가 차면 발행하는 쪽이 기다림
+			queue = nil
+		}
+		select {
+		case <-c.room:
+		case m := <-queue:
+			if m.qos > 0 {
+				m.id = c.track(m)
+			}
+			if err := write(mqttPublishPacket(m)); err != nil {
+				return err // QoS 0은 잃고, QoS 1은 다음 연결에서 다시 보냄
+			}
+			if m.qos == 0 && m.acked != nil {
+				close(m.acked)
+			}
+		case <-ping.C:
+			if err := write(mqttPacket(mqttPingreq << 4)); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		}
+	}
+}
+
+func (c *mqttClient) full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.inflight) >= mqttMaxInflight
+}
+
+// 사용 중이 아닌 패킷 ID를 붙여 PUBACK을 기다리는 목록에 추가
+// -> inflight는 mqttMaxInflight를 넘지 않으므로 빈 ID가 항상 있음
+func (c *mqttClient) track(m mqttMessage) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		c.nextId++
+		if c.nextId != 0 && !slices.ContainsFunc(c.inflight, func(p mqttMessage) bool { return p.id == c.nextId }) {
+			break
+		}
+	}
+	m.id = c.nextId
+	c.inflight = append(c.inflight, m)
+	return m.id
+}
+
+func (c *mqttClient) acknowledge(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight = slices.DeleteFunc(c.inflight, func(m mqttMessage) bool {
+		if m.id != id {
+			return false
+		}
+		if m.acked != nil {
+			close(m.acked)
+		}
+		return true
+	})
+	select {
+	case c.room <- struct{}{}:
+	default:
+	}
+}
+
+// 모든 컬렉션의 피드를 따라가며 이벤트를 MQTT 메시지로 바꿔 발행
+// -> 피드가 바뀔 때마다 feedListener가 표시만 하고, 고루틴 하나가 표시된 피드를 읽음 (커밋을 막지 않음)
+type mqttBridge struct {
+	send  func(mqttMessage)
+	mu    sync.Mutex
+	dirty map[*changeFeed]bool
+	wake  chan struct{}
+	last  map[*changeFeed]uint64 // 피드마다 발행한 마지막 버전 (run 고루틴에서만)
+}
+
+// send는 외부 브로커에 연결한 클라이언트의 publish 또는 내장 브로커의 route (-transport=mqtt인 Rx)
+func startMQTTBridge(send func(mqttMessage), target string) {
+	if mqttQoS != 0 && mqttQoS != 1 {
+		log.Fatalf("-mqtt_qos must be 0 or 1")
+	}
+	if mqttFormat != "json" && mqttFormat != "protobuf" {
+		log.Fatalf("-mqtt_format must be json or protobuf")
+	}
+	b := &mqttBridge{
+		send:  send,
+		dirty: make(map[*changeFeed]bool),
+		wake:  make(chan struct{}, 1),
+		last:  make(map[*changeFeed]uint64),
+	}
+	feedListener = b.notify
+	go b.run()
+	log.Printf("Publishing changes to %s (qos %d, retain %t, %s)", target, mqttQoS, mqttRetain, mqttFormat)
+}
+
+func (b *mqttBridge) notify(f *changeFeed) {
+	b.mu.Lock()
+	b.dirty[f] = true
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *mqttBridge) run() {
+	for range b.wake {
+		b.mu.Lock()
+		dirty := b.dirty
+		b.dirty = make(map[*changeFeed]bool)
+		b.mu.Unlock()
+		for f := range dirty {
+			events, current, _, ok := f.since(b.last[f])
+			if !ok {
+				log.Printf("MQTT bridge fell behind on collection %q: events after version %d were dropped from the change feed", f.name, b.last[f])
+			}
+			for _, e := range events {
+				if e.Op == opPurge && !mqttRetain { // 지울 보관 메시지가 없음
+					continue
+				}
+				m, err := b.message(f.name, e)
+				if err != nil {
+					log.Printf("Failed to encode change of %q record %d for MQTT: %v", f.name, e.Id, err)
+					continue
+				}
+				b.send(m)
+			}
+			b.last[f] = current
+		}
+	}
+}
+
+func (b *mqttBridge) message(collection string, e changeEvent) (mqttMessage, error) {
+	m := mqttMessage{topic: mqttTopic(collection, e.Id), qos: byte(mqttQoS), retain: mqttRetain}
+	var err error
+	if e.Op == opPurge { // 빈 보관 메시지 -> 브로커가 토픽에 남은 툼스톤을 지움
+		return m, nil
+	}
+	if mqttFormat == "protobuf" {
+		m.payload, err = proto.Marshal(e.record)
+	} else {
+		e.Collection = collection
+		m.payload, err = e.marshal()
+	}
+	return m, err
+}
+
+// 최소 MQTT 브로커 (-mode=broker) -> 브리지를 외부 브로커 없이 확인하기 위한 것 ------------------------
+// CONNECT, PUBLISH (QoS 0, 1), SUBSCRIBE/UNSUBSCRIBE (+, # 와일드카드), 보관 메시지, PINGREQ만 지원
+// -> 세션은 연결이 끊기면 사라짐 (clean session), 구독자에게 보낸 QoS 1 메시지는 다시 보내지 않음
+// -> -transport=mqtt인 Rx도 같은 브로커를 내장해 Tx의 패키지를 받음
+
+type mqttBroker struct {
+	mu        sync.Mutex
+	sessions  map[string]*mqttSession // 클라이언트 ID -> 세션
+	retained  map[string]mqttMessage  // 토픽 -> 보관 메시지
+	onPublish func(m mqttMessage)     // 클라이언트가 발행한 메시지를 구독자에게 보내기 전에 호출 (PUBACK도 그 뒤)
+
+	authorize  func(s *mqttSession) byte               // 있으면 CONNECT마다 호출 -> 0이 아니면 그 반환 코드로 거절
+	canPublish func(s *mqttSession, topic string) bool // 있으면 PUBLISH마다 호출 -> false면 연결을 끊음
+}
+
+type mqttSession struct {
+	clientId string
+	username string
+	password string
+	conn     net.Conn
+	writeMu  sync.Mutex
+	subs     map[string]byte // 토픽 필터 -> 허용한 QoS (broker.mu로 보호)
+	nextId   uint16
+}
+
+func newMQTTBroker() *mqttBroker {
+	return &mqttBroker{sessions: make(map[string]*mqttSession), retained: make(map[string]mqttMessage)}
+}
+
+func (b *mqttBroker) listen(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to start MQTT broker: %v", err)
+	}
+	log.Printf("Starting MQTT broker on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Failed to accept MQTT connection: %v", err)
+			continue
+		}
+		go b.serveConn(conn)
+	}
+}
+
+func (b *mqttBroker) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	header, body, err := readMQTTPacket(r)
+	if err != nil || header>>4 != mqttConnect {
+		log.Printf("MQTT client %s did not send CONNECT: %v", conn.RemoteAddr(), err)
+		return
+	}
+	s, keepAlive, code := parseMQTTConnect(body)
+	if code != 0 {
+		conn.Write(mqttPacket(mqttConnack<<4, []byte{0, code}))
+		log.Printf("Refused MQTT client %s (return code %d)", conn.RemoteAddr(), code)
+		return
+	}
+	if s.clientId == "" {
+		s.clientId = "auto-" + newRequestId()
+	}
+	if b.authorize != nil {
+		code = b.authorize(s)
+	}
+	if code != 0 {
+		conn.Write(mqttPacket(mqttConnack<<4, []byte{0, code}))
+		log.Printf("Refused MQTT client %q from %s (return code %d)", s.clientId, conn.RemoteAddr(), code)
+		return
+	}
+	s.conn = conn
+	s.subs = make(map[string]byte)
+
+	b.mu.Lock()
+	if old, ok := b.sessions[s.clientId]; ok { // 같은 ID로 다시 연결하면 이전 연결을 끊음
+		old.conn.Close()
+	}
+	b.sessions[s.clientId] = s
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		if b.sessions[s.clientId] == s {
+			delete(b.sessions, s.clientId)
+		}
+		b.mu.Unlock()
+	}()
+
+	s.write(mqttPacket(mqttConnack<<4, []byte{0, 0}))
+	log.Printf("MQTT client %q connected from %s", s.clientId, conn.RemoteAddr())
+	for {
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			log.Printf("MQTT client %q disconnected: %v", s.clientId, err)
+			return
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			m, err := parseMQTTPublish(header, body)
+			if err != nil {
+				log.Printf("Invalid PUBLISH from MQTT client %q: %v", s.clientId, err)
+				return
+			}
+			if b.canPublish != nil && !b.canPublish(s, m.topic) {
+				log.Printf("MQTT client %q is not allowed to publish to %q, disconnecting", s.clientId, m.topic)
+				return
+			}
+			if b.onPublish != nil {
+				b.onPublish(m)
+			}
+			b.route(m)
+			if m.qos == 1 {
+				s.write(mqttPacket(mqttPuback<<4, binary.BigEndian.AppendUint16(nil, m.id)))
+			}
+		case mqttSubscribe:
+			if err := b.subscribe(s, body); err != nil {
+				log.Printf("Invalid SUBSCRIBE from MQTT client %q: %v", s.clientId, err)
+				return
+			}
+		case mqttUnsubscribe:
+			if err := b.unsubscribe(s, body); err != nil {
+				log.Printf("Invalid UNSUBSCRIBE from MQTT client %q: %v", s.clientId, err)
+				return
+			}
+		case mqttPingreq:
+			s.write(mqttPacket(mqttPingresp << 4))
+		case mqttPuback:
+		case mqttDisconnect:
+			log.Printf("MQTT client %q disconnected", s.clientId)
+			return
+		default:
+			log.Printf("Unsupported MQTT packet type %d from %q", header>>4, s.clientId)
+			return
+		}
+	}
+}
+
+// CONNECT 본문 -> 세션, keep alive, CONNACK 반환 코드 (0이면 수락)
+func parseMQTTConnect(body []byte) (*mqttSession, time.Duration, byte) {
+	name, rest, err := readMQTTString(body)
+	if err != nil || len(rest) < 4 {
+		return nil, 0, 1
+	}
+	if name != "MQTT" || rest[0] != 4 {
+		return nil, 0, 1 // 3.1.1만 지원
+	}
+	flags := rest[1]
+	keepAlive := time.Duration(binary.BigEndian.Uint16(rest[2:])) * time.Second
+	clientId, rest, err := readMQTTString(rest[4:])
+	if err != nil {
+		return nil, 0, 2
+	}
+	if clientId == "" && flags&0x02 == 0 {
+		return nil, 0, 2 // ID 없이 세션을 유지할 수는 없음
+	}
+	s := &mqttSession{clientId: clientId}
+	if flags&0x04 != 0 { // will 토픽과 메시지 (지원하지 않으므로 건너뜀)
+		if _, rest, err = readMQTTString(rest); err == nil {
+			_, rest, err = readMQTTString(rest)
+		}
+	}
+	if err == nil && flags&0x80 != 0 {
+		s.username, rest, err = readMQTTString(rest)
+	}
+	if err == nil && flags&0x40 != 0 {
+		s.password, _, err = readMQTTString(rest)
+	}
+	if err != nil {
+		return nil, 0, 4 // bad user name or password
+	}
+	return s, keepAlive, 0
+}
+
+func (s *mqttSession) write(packet []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.conn.Write(packet); err != nil {
+		s.conn.Close() // 느리거나 끊긴 구독자 -> 읽기 루프가 정리
+	}
+}
+
+// 구독자에게 보냄 -> QoS는 발행과 구독 중 낮은 쪽
+func (s *mqttSession) deliver(m mqttMessage, qos byte) {
+	m.qos = min(m.qos, qos)
+	m.dup = false
+	if m.qos > 0 {
+		s.writeMu.Lock()
+		s.nextId++
+		if s.nextId == 0 {
+			s.nextId = 1
+		}
+		m.id = s.nextId
+		s.writeMu.Unlock()
+	}
+	s.write(mqttPublishPacket(m))
+}
+
+func (b *mqttBroker) route(m mqttMessage) {
+	type target struct {
+		session *mqttSession
+		qos     byte
+	}
+	var targets []target
+	b.mu.Lock()
+	if m.retain {
+		if len(m.payload) == 0 {
+			delete(b.retained, m.topic) // 빈 보관 메시지는 보관된 값을 지움
+		} else {
+			b.retained[m.topic] = m
+		}
+	}
+	for _, s := range b.sessions {
+		granted, matched := byte(0), false
+		for filter, qos := range s.subs {
+			if mqttTopicMatches(filter, m.topic) {
+				granted, matched = max(granted, qos), true
+			}
+		}
+		if matched {
+			targets = append(targets, target{s, granted})
+		}
+	}
+	b.mu.Unlock()
+	m.retain = false // 이미 구독 중인 클라이언트에게는 보관 플래그 없이
+	for _, t := range targets {
+		t.session.deliver(m, t.qos)
+	}
+}
+
+func (b *mqttBroker) subscribe(s *mqttSession, body []byte) error {
+	if len(body) < 2 {
+		return fmt.Errorf("missing packet identifier")
+	}
+	id, rest := binary.BigEndian.Uint16(body), body[2:]
+	var codes []byte
+	var filters []string
+	for len(rest) > 0 {
+		filter, next, err := readMQTTString(rest)
+		if err != nil || len(next) < 1 {
+			return fmt.Errorf("malformed topic filter")
+		}
+		qos := min(next[0]&0x03, 1)
+		rest = next[1:]
+		if !validMQTTFilter(filter) {
+			codes = append(codes, 0x80)
+			continue
+		}
+		b.mu.Lock()
+		s.subs[filter] = qos
+		b.mu.Unlock()
+		codes = append(codes, qos)
+		filters = append(filters, filter)
+	}
+	if len(codes) == 0 {
+		return fmt.Errorf("no topic filters")
+	}
+	s.write(mqttPacket(mqttSuback<<4, binary.BigEndian.AppendUint16(nil, id), codes))
+	log.Printf("MQTT client %q subscribed to %q", s.clientId, filters)
+
+	// 새 구독에 맞는 보관 메시지를 보관 플래그와 함께 전송
+	b.mu.Lock()
+	var retained []mqttMessage
+	for topic, m := range b.retained {
+		for _, filter := range filters {
+			if mqttTopicMatches(filter, topic) {
+				retained = append(retained, m)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	slices.SortFunc(retained, func(x, y mqttMessage) int { return cmp.Compare(x.topic, y.topic) })
+	for _, m := range retained {
+		s.deliver(m, s.grantedQoS(b, m.topic))
+	}
+	return nil
+}
+
+func (s *mqttSession) grantedQoS(b *mqttBroker, topic string) byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	granted := byte(0)
+	for filter, qos := range s.subs {
+		if mqttTopicMatches(filter, topic) {
+			granted = max(granted, qos)
+		}
+	}
+	return granted
+}
+
+func (b *mqttBroker) unsubscribe(s *mqttSession, body []byte) error {
+	if len(body) < 2 {
+		return fmt.Errorf("missing packet identifier")
+	}
+	id, rest := binary.BigEndian.Uint16(body), body[2:]
+	for len(rest) > 0 {
+		filter, next, err := readMQTTString(rest)
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		delete(s.subs, filter)
+		b.mu.Unlock()
+		rest = next
+	}
+	s.write(mqttPacket(mqttUnsuback<<4, binary.BigEndian.AppendUint16(nil, id)))
+	return nil
+}
+
+// +는 한 단계, #는 마지막에서 나머지 전체 (부모 단계 포함)
+func validMQTTFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+func mqttTopicMatches(filter, topic string) bool {
+	filterLevels, topicLevels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "#" || filterLevels[0] == "+") {
+		return false // $로 시작하는 토픽은 와일드카드로 구독하지 않음
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// Redis 호환 조회 (-redis_listen) -> redis-cli 등으로 RxData를 읽을 수 있도록 RESP2로 응답 ---------------------
+// 키: data:{id} (기본 컬렉션), {name}:data:{id} (컬렉션 이름에는 :가 없음)
+// GET -> 레코드 JSON (/data/{id}와 같은 형식), HGETALL -> CSV 내보내기와 같은 열 이름과 값 (레코드에 있는 필드만)
+// SCAN cursor [MATCH pattern] [COUNT n], KEYS, EXISTS, DBSIZE, PING, ECHO, SELECT 0, QUIT만 지원 (조회 전용)
+// -> 동적 스키마 컬렉션은 포함하지 않음
+
+const (
+	redisMaxArgs     = 1024
+	redisMaxBulk     = 1024 * 1024
+	redisDefaultScan = 10
+)
+
+var redisListen string // -redis_listen: 비어 있으면 사용하지 않음
+
+// 인자 수 (명령 이름 포함), 음수면 최소 개수
+var redisArity = map[string]int{"GET": 2, "HGETALL": 2, "DBSIZE": 1, "PING": -1, "ECHO": 2, "SELECT": 2, "QUIT": 1, "KEYS": 2, "EXISTS": -2, "SCAN": -2, "COMMAND": -1}
+
+func startRxRedisServer(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to start Rx Redis server: %v", err)
+	}
+	log.Printf("Rx Redis (RESP2) server started on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Failed to accept Redis connection: %v", err)
+			continue
+		}
+		go serveRedisConn(conn)
+	}
+}
+
+func serveRedisConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				writeRedisError(w, "ERR Protocol error: "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := strings.EqualFold(args[0], "QUIT")
+		handleRedisCommand(w, args)
+		if r.Buffered() == 0 || quit { // 파이프라인으로 이어 온 명령은 모아서 한 번에
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// 배열 (*N + $len 벌크 문자열) 또는 인라인 명령 (공백으로 구분한 한 줄)
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > redisMaxArgs {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	args := make([]string, 0, max(n, 0))
+	for range n {
+		line, err := readRedisLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > redisMaxBulk {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeRedisError(w *bufio.Writer, message string) {
+	fmt.Fprintf(w, "-%s\r\n", message)
+}
+
+func writeRedisBulk(w *bufio.Writer, value []byte) {
+	if value == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w, "$%d\r\n", len(value))
+	w.Write(value)
+	w.WriteString("\r\n")
+}
+
+func writeRedisArray(w *bufio.Writer, values []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(values))
+	for _, v := range values {
+		writeRedisBulk(w, []byte(v))
+	}
+}
+
+func handleRedisCommand(w *bufio.Writer, args []string) {
+	name := strings.ToUpper(args[0])
+	want, ok := redisArity[name]
+	if !ok {
+		writeRedisError(w, fmt.Sprintf("ERR unknown command '%s' (Rx is read-only: GET, HGETALL, SCAN, KEYS, EXISTS, DBSIZE)", args[0]))
+		return
+	}
+	if (want > 0 && len(args) != want) || (want < 0 && len(args) < -want) {
+		writeRedisError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+
+	switch name {
+	case "GET":
+		d := redisRecord(args[1])
+		if d == nil {
+			writeRedisBulk(w, nil)
+			return
+		}
+		record, err := marshalJSONRecord(d)
+		if err != nil {
+			writeRedisError(w, "ERR "+err.Error())
+			return
+		}
+		writeRedisBulk(w, record)
+	case "HGETALL":
+		d := redisRecord(args[1])
+		if d == nil {
+			writeRedisArray(w, nil)
+			return
+		}
+		writeRedisArray(w, redisHash(d))
+	case "EXISTS":
+		n := 0
+		for _, key := range args[1:] {
+			if redisRecord(key) != nil {
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "DBSIZE":
+		fmt.Fprintf(w, ":%d\r\n", len(redisKeys()))
+	case "KEYS":
+		keys, err := matchRedisKeys(redisKeys(), args[1])
+		if err != nil {
+			writeRedisError(w, "ERR "+err.Error())
+			return
+		}
+		writeRedisArray(w, keys)
+	case "SCAN":
+		handleRedisScan(w, args[1:])
+	case "PING":
+		if len(args) > 1 {
+			writeRedisBulk(w, []byte(args[1]))
+		} else {
+			w.WriteString("+PONG\r\n")
+		}
+	case "ECHO":
+		writeRedisBulk(w, []byte(args[1]))
+	case "SELECT":
+		if args[1] != "0" {
+			writeRedisError(w, "ERR DB index is out of range")
+			return
+		}
+		w.WriteString("+OK\r\n")
+	case "QUIT":
+		w.WriteString("+OK\r\n")
+	case "COMMAND": // redis-cli가 접속할 때 명령 도움말을 요청 -> 없음
+		writeRedisArray(w, nil)
+	}
+}
+
+// SCAN cursor [MATCH pattern] [COUNT n] -> 커서는 정렬한 키 목록의 위치
+// (그 사이 앞쪽 레코드가 삭제되면 일부 키를 건너뛸 수 있음)
+func handleRedisScan(w *bufio.Writer, args []string) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		writeRedisError(w, "ERR invalid cursor")
+		return
+	}
+	pattern, count := "*", redisDefaultScan
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			writeRedisError(w, "ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				writeRedisError(w, "ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			writeRedisError(w, "ERR syntax error")
+			return
+		}
+	}
+
+	keys := redisKeys()
+	end := min(cursor+count, len(keys))
+	if cursor > end {
+		cursor = end
+	}
+	// COUNT는 살펴볼 키 수 (Redis와 같이 MATCH에 맞는 키가 그보다 적을 수 있음)
+	page, err := matchRedisKeys(keys[cursor:end], pattern)
+	if err != nil {
+		writeRedisError(w, "ERR "+err.Error())
+		return
+	}
+	next := strconv.Itoa(end)
+	if end == len(keys) {
+		next = "0"
+	}
+	w.WriteString("*2\r\n")
+	writeRedisBulk(w, []byte(next))
+	writeRedisArray(w, page)
+}
 
-	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
-)
+func matchRedisKeys(keys []string, pattern string) ([]string, error) {
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ok, err := path.Match(pattern, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		if ok {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
 
-const (
-	httpPort  = "8080"
-	httpsPort = "8443"
-	tcpPort   = "1884"
-)
+func redisKey(collection string, id int64) string {
+	if collection == defaultCollection {
+		return fmt.Sprintf("data:%d", id)
+	}
+	return fmt.Sprintf("%s:data:%d", collection, id)
+}
 
-type sData struct {
-	Id      int    `json:"id"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Sex     string `json:"sex"`
+// 컬렉션 이름 순, 컬렉션 안에서는 ID 순으로 살아 있는 레코드의 키
+func redisKeys() []string {
+	collectionsMutex.Lock()
+	names := slices.Sorted(maps.Keys(rxCollections))
+	collections := make([]*rxCollection, len(names))
+	for i, name := range names {
+		collections[i] = rxCollections[name]
+	}
+	collectionsMutex.Unlock()
+
+	var keys []string
+	for i, c := range collections {
+		c.mu.RLock()
+		ids := make([]int64, 0, len(c.data))
+		for _, d := range liveData(c.data) {
+			ids = append(ids, d.Id)
+		}
+		c.mu.RUnlock()
+		slices.Sort(ids)
+		for _, id := range ids {
+			keys = append(keys, redisKey(names[i], id))
+		}
+	}
+	return keys
 }
 
-var TxData []*pt.Data
-var RxData []*pt.Data
+// 키의 레코드 (없거나 삭제되었거나 키 형식이 아니면 nil)
+func redisRecord(key string) *pt.Data {
+	name, rest := defaultCollection, key
+	if before, after, ok := strings.Cut(key, ":data:"); ok {
+		name, rest = before, "data:"+after
+	}
+	idText, ok := strings.CutPrefix(rest, "data:")
+	if !ok {
+		return nil
+	}
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		return nil
+	}
+	c := rxCollectionFor(name, false)
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return findRecord(c.data, id)
+}
 
-// var rxDataMutex sync.RWMutex
+// HGETALL 응답 -> 레코드에 저장된 필드만 (요청 전용인 ttl과 값이 없는 필드는 뺌)
+func redisHash(d *pt.Data) []string {
+	var fields []string
+	for i, value := range csvRow(d) {
+		if column := csvColumns[i]; column != "ttl" && value != "" {
+			fields = append(fields, column, value)
+		}
+	}
+	return fields
+}
 
-func main() {
-	mode := flag.String("mode", "tx", "tx(transport) or rx(receive)")
-	protocol := flag.String("pro", "http", "http or https")
-	flag.Parse()
+type dynamicSchema struct {
+	proto   *pt.Schema // Rx로 그대로 전송
+	message protoreflect.MessageDescriptor
+}
 
-	if *mode == "tx" {
-		startTxServer(*protocol)
-	} else if *mode == "rx" {
-		startRxServer(*protocol)
-	} else {
-		fmt.Println("tx와 rx 중 입력 바람")
-		os.Exit(1)
+func (s *dynamicSchema) name() string {
+	if s == nil {
+		return ""
 	}
+	return string(s.message.FullName())
 }
 
-func startTxServer(protocol string) {
-	if protocol == "http" {
-		log.Printf("Starting HTTP Tx server on port %s", httpPort)
-		http.HandleFunc("/", handleTxRequest)                          // 요청 처리 함수 설정
-		if err := http.ListenAndServe(":"+httpPort, nil); err != nil { // HTTP 서버 실행
-			log.Fatalf("Failed to start HTTP Tx server: %v", err)
-		}
-	} else if protocol == "https" {
-		log.Printf("Starting HTTPS Tx server on port %s", httpsPort)
-		http.HandleFunc("/", handleTxRequest)
-		if err := http.ListenAndServeTLS(":"+httpsPort, "cert.pem", "key.pem", nil); err != nil {
-			log.Fatalf("Failed to start HTTPS Tx server: %v", err)
-		}
-	} else {
-		log.Print("http와 https 중 입력 바람")
-		os.Exit(1)
+// FileDescriptorSet에서 메시지 형식을 찾음
+// -> 다른 .proto를 import하면 protoc --include_imports로 만든 descriptor set이어야 함
+func parseSchema(descriptorSet []byte, messageName string) (*dynamicSchema, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set (build it with --include_imports): %v", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("message %q not found in descriptor set", messageName)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message", messageName)
 	}
+	return &dynamicSchema{
+		proto:   &pt.Schema{DescriptorSet: descriptorSet, Message: messageName},
+		message: message,
+	}, nil
 }
 
-func startRxServer(protocol string) {
-	if protocol == "http" {
-		log.Printf("Starting HTTP Rx server on port %s", httpPort)
-		go startRxTcpServer() // tcp 소켓으로부터 데이터 수신하도록
-		http.HandleFunc("/", handleRxRequest)
-		if err := http.ListenAndServe(":"+httpPort, nil); err != nil {
-			log.Fatalf("Failed to start HTTP Rx server: %v", err)
-		}
-	} else if protocol == "https" {
-		log.Printf("Starting HTTPS Rx server on port %s", httpsPort)
-		go startRxTcpServer()
-		http.HandleFunc("/", handleRxRequest)
-		if err := http.ListenAndServeTLS(":"+httpsPort, "cert.pem", "key.pem", nil); err != nil {
-			log.Fatalf("Failed to start HTTPS Rx server: %v", err)
-		}
-	} else {
-		log.Print("http와 https 중 입력 바람")
-		os.Exit(1)
+// -schema team-b=bench.pb:bench.Payload -> 서버 시작 시 동적 스키마 컬렉션 생성
+func addSchemaFlag(value string) error {
+	name, spec, ok := strings.Cut(value, "=")
+	path, messageName, ok2 := strings.Cut(spec, ":")
+	if !ok || !ok2 || !collectionNamePattern.MatchString(name) {
+		return fmt.Errorf("expected name=descriptor_set.pb:package.Message")
 	}
+	descriptorSet, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	schema, err := parseSchema(descriptorSet, messageName)
+	if err != nil {
+		return err
+	}
+	_, err = createDynamicCollection(name, schema)
+	return err
 }
 
-func handleTxRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		start := time.Now()
-		responseData, err := json.Marshal(TxData)
+func createDynamicCollection(name string, schema *dynamicSchema) (*txCollection, error) {
+	collectionsMutex.Lock()
+	defer collectionsMutex.Unlock()
+	if existing, ok := txCollections[name]; ok && existing.exists() {
+		return nil, fmt.Errorf("collection %q already exists", name)
+	}
+	c := newTxCollection(name)
+	c.schema = schema
+	txCollections[name] = c
+	log.Printf("Created collection %q with schema %s", name, schema.name())
+	return c, nil
+}
+
+// PUT /collections/{name}/schema?message=bench.Payload (본문: FileDescriptorSet) -> 동적 스키마 컬렉션 생성
+// GET -> 컬렉션의 메시지 이름과 필드 목록
+func handleTxSchema(w http.ResponseWriter, r *http.Request) {
+	name, err := collectionName(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		c := txCollectionFor(name, false)
+		if c == nil || c.schema == nil {
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("collection %q has no dynamic schema", name))
+			return
+		}
+		fields := c.schema.message.Fields()
+		fieldNames := make([]string, fields.Len())
+		for i := range fields.Len() {
+			fieldNames[i] = string(fields.Get(i).Name())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"collection": name, "message": c.schema.name(), "fields": fieldNames})
+	case http.MethodPut:
+		descriptorSet, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Printf("Failed to marshal Tx data: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("failed to read body: %v", err))
+			return
+		}
+		schema, err := parseSchema(descriptorSet, r.URL.Query().Get("message"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := createDynamicCollection(name, schema); err != nil {
+			writeJSONError(w, http.StatusConflict, err.Error())
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.Write(responseData)
-		end := time.Since(start)
-		//log.Println("Tx - Processed GET request")
-		fmt.Printf("-- Tx_Time elapsed for GET request: %d ms.\n", end.Milliseconds())
-	} else if r.Method == http.MethodPost {
-		processTxData(r, "POST")
-		//log.Println("Tx - Processed POST request")
-	} else if r.Method == http.MethodPut {
-		processTxData(r, "PUT")
-		//log.Println("Tx - Processed PUT request")
-	} else if r.Method == http.MethodDelete {
-		processTxData(r, "DELETE")
-		//log.Println("Tx - Processed DELETE request")
-	} else {
-		log.Println("Method not allowed")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"collection": name, "message": schema.name()})
+	default:
+		methodNotAllowed(w, "GET", "PUT")
 	}
 }
 
-func handleRxRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		start := time.Now()
-		responseData, err := json.Marshal(RxData)
+// 레코드 목록을 protojson 배열로
+func marshalRecords(records []*dynamicpb.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, record := range records {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		jsonData, err := marshalCanonical(record)
 		if err != nil {
-			log.Printf("Failed to marshal Rx data: %v", err)
-			return // 에러가 발생하면 함수 종료
+			return nil, err
+		}
+		buf.Write(jsonData)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// 동적 스키마 컬렉션 -> GET은 전체 조회, POST는 replace(기본) 또는 append
+// (레코드 형식이 정해져 있지 않아 ID로 수정/삭제하는 기능은 없음)
+func handleTxDynamicRequest(w http.ResponseWriter, r *http.Request, c *txCollection) {
+	switch r.Method {
+	case http.MethodGet:
+		c.mu.RLock()
+		version := c.version
+		if notModified(r, datasetETag(version)) {
+			c.mu.RUnlock()
+			w.Header().Set("ETag", datasetETag(version))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		responseData, err := marshalRecords(c.records)
+		c.mu.RUnlock()
+		if err != nil {
+			writeInternalError(w, fmt.Errorf("failed to marshal Tx records: %w", err))
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", datasetETag(version))
 		w.Write(responseData)
-		end := time.Since(start)
-		//log.Println("Rx - Processed GET request")
-		fmt.Printf("-- Rx_Time elapsed for GET request: %d ms.\n", end.Milliseconds())
-	} else {
-		log.Println("Method not allowed")
+	case http.MethodPost:
+		mode, err := writeModeFor(r, "POST")
+		if err == nil && mode != writeReplace && mode != writeAppend {
+			err = fmt.Errorf("write mode %q is not supported for dynamic schemas (use replace or append)", mode)
+		}
+		if err != nil {
+			log.Printf("Invalid request: %v", err)
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var rawList []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&rawList); err != nil {
+			log.Printf("Invalid data format: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid data format: %v", err))
+			return
+		}
+		// JSON -> 스키마의 메시지 (모르는 필드나 형식이 맞지 않는 값이 있으면 전체 거부)
+		records := make([]*dynamicpb.Message, len(rawList))
+		for i, raw := range rawList {
+			records[i] = dynamicpb.NewMessage(c.schema.message)
+			if err := protojson.Unmarshal(raw, records[i]); err != nil {
+				writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("record %d: %v", i, err))
+				return
+			}
+		}
+
+		c.mu.Lock()
+		if !ifMatchOK(r, datasetETag(c.version)) {
+			version := c.version
+			c.mu.Unlock()
+			writePreconditionFailed(w, version)
+			return
+		}
+		if mode == writeAppend {
+			records = append(slices.Clip(c.records), records...) // 기존 슬라이스는 전송 중일 수 있으므로 새로 할당
+		}
+		dataPackage := &pt.DataPackage{
+			TotalCount: int32(len(records)),
+			Schema:     c.schema.proto,
+			Records:    make([][]byte, len(records)),
+		}
+		for i, record := range records {
+			if dataPackage.Records[i], err = proto.Marshal(record); err != nil { // 하나라도 실패하면 커밋하지 않음
+				c.mu.Unlock()
+				writeInternalError(w, fmt.Errorf("failed to marshal record %d: %w", i, err))
+				return
+			}
+		}
+		c.records = records
+		version := commitAndReplicate(c, dataPackage, nil) // 동적 스키마 컬렉션은 버전만 알림
+		log.Printf("POST request (%s) processed for %d records in %q.\n", mode, len(rawList), c.name)
+
+		writeResults(w, http.StatusOK, writeResponse{Mode: mode, Version: version, Count: len(records), Summary: map[string]int{outcomeCreated: len(rawList)}})
+	default:
+		methodNotAllowed(w, "GET", "POST")
 	}
 }
 
-func processTxData(r *http.Request, method string) {
-	// 여러 개의 데이터를 처리하도록 수정 (슬라이스 적용)
-	var dataList []sData                                              // 클라이언트가 보낸 데이터 목록 -> JSON으로 디코딩된 구조체(sData) 형태
-	if err := json.NewDecoder(r.Body).Decode(&dataList); err != nil { // HTTP 요청의 본문 (r.Body)에서 데이터를 읽어와서 dataList 변수에 파싱
-		log.Printf("Invalid data format: %v", err)
+func handleRxDynamicRequest(w http.ResponseWriter, r *http.Request, c *rxCollection) {
+	c.mu.RLock()
+	version := c.version
+	if notModified(r, datasetETag(version)) {
+		c.mu.RUnlock()
+		w.Header().Set("ETag", datasetETag(version))
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-
-	if method == "POST" {
-		start := time.Now()
-		// 받은 데이터를 TxData로 덮어쓰기 (기존 데이터는 모두 삭제)
-		var txList []*pt.Data
-		for _, data := range dataList {
-			// dataList를 순회하며 각 구조체 요소를 *pt.Data 프로토버프 형삭으로 변환.
-			txProtobuf := &pt.Data{
-				Id:      int32(data.Id),
-				Name:    data.Name,
-				Address: data.Address,
-				Sex:     data.Sex,
-			}
-			txList = append(txList, txProtobuf)
-		}
-		TxData = txList // TxData를 새로 받은 데이터로 교체
-		end := time.Since(start)
-		log.Printf("POST request processed for %d data.\n", len(dataList))
-		log.Printf("Current TxData: %+v\n", TxData)                                     // TxData 출력
-		fmt.Printf("-- Tx_Time elapsed for POST request: %d ms.\n", end.Milliseconds()) // 소요 시간 출력
+	responseData, err := marshalRecords(c.records)
+	c.mu.RUnlock()
+	if err != nil {
+		writeInternalError(w, fmt.Errorf("failed to marshal Rx records: %w", err))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", datasetETag(version))
+	w.Write(responseData)
+}
 
-	if method == "PUT" {
-		start := time.Now()
-		for _, data := range dataList {
-			found := false
-			for i, existingData := range TxData { // i는 현재 항목의 인덱스, existingData는 그 항목의 값
-				if existingData.Id == int32(data.Id) {
-					// 기존 Tx 데이터 갱신
-					TxData[i] = &pt.Data{
-						Id:      int32(data.Id),
-						Name:    data.Name,
-						Address: data.Address,
-						Sex:     data.Sex,
-					}
-					found = true
-					break
-				}
-			}
-			if !found {
-				log.Printf("PUT request: ID %d not found, skipping update.\n", data.Id)
-			} else {
-				log.Printf("PUT request processed for ID %d.\n", data.Id)
-			}
+// 동적 스키마 패키지를 Rx 컬렉션에 반영 (호출하는 쪽에서 c.mu를 잠근 상태여야 함)
+func applyDynamicPackage(c *rxCollection, dataPackage *pt.DataPackage) {
+	schema := c.schema
+	if schema == nil || !proto.Equal(schema.proto, dataPackage.Schema) { // 처음 받았거나 Tx에서 스키마가 바뀜
+		var err error
+		schema, err = parseSchema(dataPackage.Schema.DescriptorSet, dataPackage.Schema.Message)
+		if err != nil {
+			log.Printf("Invalid schema in package (%v), keeping current records.", err)
+			return
 		}
-		end := time.Since(start)
-		log.Printf("Current TxData: %+v\n", TxData)                                    // TxData 출력
-		fmt.Printf("-- Tx_Time elapsed for PUT request: %d ms.\n", end.Milliseconds()) // 소요 시간 출력
 	}
-
-	if method == "DELETE" {
-		start := time.Now()
-		for _, data := range dataList {
-			found := false
-			for i, existingData := range TxData {
-				if existingData.Id == int32(data.Id) {
-					// 슬라이스에서 해당 데이터 삭제
-					TxData = append(TxData[:i], TxData[i+1:]...) // 0번째부터 i-1번째, i+1번째부터 마지막까지의 모든 요소 합치기
-					found = true
-					break
-				}
-			}
-			if !found {
-				log.Printf("DELETE request: ID %d not found, skipping deletion.\n", data.Id)
-			} else {
-				log.Printf("DELETE request processed for ID %d.\n", data.Id)
-			}
+	records := make([]*dynamicpb.Message, len(dataPackage.Records))
+	for i, raw := range dataPackage.Records {
+		records[i] = dynamicpb.NewMessage(schema.message)
+		if err := proto.Unmarshal(raw, records[i]); err != nil {
+			log.Printf("Invalid record %d in package (%v), keeping current records.", i, err)
+			return
 		}
-		end := time.Since(start)
-		log.Printf("Current TxData: %+v\n", TxData)                                       // TxData 출력
-		fmt.Printf("-- Tx_Time elapsed for DELETE request: %d ms.\n", end.Milliseconds()) // 소요 시간 출력
-	}
-
-	// Rx 서버로 데이터 패키지 전송
-	dataPackage := &pt.DataPackage{
-		DataList:   TxData,             // 여러 개의 pt.Data 구조체를 가진 슬라이스
-		TotalCount: int32(len(TxData)), //  TxData에 포함된 데이터 항목의 개수
 	}
-	err := sendToRx(dataPackage)
-	if err != nil {
-		log.Printf("Error sending data to Rx server: %v", err)
+	if int(dataPackage.TotalCount) != len(records) {
+		log.Printf("Data count mismatch, keeping current records.")
+		return
 	}
+	log.Printf("Data count matches, updating %s records with received data.", schema.name())
+	c.schema, c.records, c.version = schema, records, dataPackage.Version
+	c.data, c.index = nil, make(map[int64]int)
 }
 
 func sendToRx(dataPackage *pt.DataPackage) error {
+	if rxTransport == "mqtt" {
+		return publishToRx(dataPackage)
+	}
+
 	// TCP 연결 설정
 	conn, err := net.Dial("tcp", "localhost:"+tcpPort)
 	if err != nil {
@@ -291,18 +5151,155 @@ func startRxTcpServer() {
 	defer listener.Close()
 
 	log.Printf("Rx TCP server started on port %s\n", tcpPort)
+	var turn uint64
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.Printf("Failed to accept connection: %v", err)
 			continue
 		}
-		go handleRxConn(conn) // 각 클라이언트와의 연결을 병렬로 처리
+		go handleRxConn(conn, turn) // 각 클라이언트와의 연결을 병렬로 처리
+		turn++
+	}
+}
+
+// -transport에 따라 Tx의 패키지를 받는 방식
+func startRxReceiver() {
+	if rxTransport == "mqtt" {
+		startRxMQTTBroker()
+		return
+	}
+	startRxTcpServer()
+}
+
+// MQTT 전송 (-transport=mqtt) -> 길이 + protobuf 프레임 대신 MQTT로 패키지를 전달 (두 방식의 성능 비교용) ---------
+// Tx: 연결 하나를 유지하며 패키지를 prototest/packages로 QoS 1 발행, PUBACK을 받으면 전송 완료
+// Rx: 내장 브로커 (mqttPort)가 받은 순서대로 반영한 뒤 PUBACK -> 외부 MQTT 클라이언트는 같은 브로커에서
+// 레코드 토픽 (prototest/data/{id}, -mqtt_broker와 같은 형식)이나 패키지 토픽을 구독할 수 있음
+// -> 패키지 토픽에는 Tx의 클라이언트 ID (-transport_password와 비밀번호도 맞아야 함)만 발행할 수 있음,
+//    다른 클라이언트가 발행하면 연결을 끊음
+
+const (
+	rxPublishTimeout = 5 * time.Second // 이 시간 안에 PUBACK이 없으면 실패로 응답 (패키지는 큐에 남아 다시 연결한 뒤 전송)
+	rxPublisherId    = "prototest-tx"  // Tx가 Rx의 내장 브로커에 연결할 때 쓰는 클라이언트 ID
+)
+
+var (
+	rxTransport string      // -transport: tcp 또는 mqtt
+	rxPassword  string      // -transport_password: -transport=mqtt이면 필수
+	rxPublisher *mqttClient // Tx가 Rx의 내장 브로커에 연결한 클라이언트
+)
+
+func rxPackageTopic() string {
+	return mqttTopicPrefix + "/packages"
+}
+
+func startRxPublisher() {
+	rxPublisher = newMQTTClient("localhost:"+mqttPort, rxPublisherId)
+	rxPublisher.password = rxPassword
+	go rxPublisher.run()
+}
+
+func publishToRx(dataPackage *pt.DataPackage) error {
+	data, err := proto.Marshal(dataPackage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data package: %w", err)
+	}
+	if len(data)+len(rxPackageTopic())+4 > mqttMaxPacket { // Rx 브로커가 연결을 끊으므로 다시 보내도 계속 실패
+		return fmt.Errorf("data package is too large for the MQTT transport (%d bytes, limit %d)", len(data), mqttMaxPacket)
+	}
+	start := time.Now()
+	timeout := time.NewTimer(rxPublishTimeout) // Rx가 내려가 큐가 가득 찬 경우에도 쓰기 요청이 계속 멈춰 있지 않도록
+	defer timeout.Stop()
+	acked := make(chan struct{})
+	select {
+	case rxPublisher.queue <- mqttMessage{topic: rxPackageTopic(), payload: data, qos: 1, acked: acked}:
+	case <-timeout.C:
+		return fmt.Errorf("failed to queue data package for Rx broker: queue is full")
+	}
+	select {
+	case <-acked:
+	case <-timeout.C:
+		return fmt.Errorf("no PUBACK from Rx broker within %s (the package is resent after reconnecting)", rxPublishTimeout)
+	}
+	log.Printf("Tx server published %d bytes to Rx broker. (protobuf, MQTT) \n", len(data))
+	fmt.Printf("-- Tx_Time elapsed for MQTT Publishing: %d ms.\n", time.Since(start).Milliseconds())
+	return nil
+}
+
+func startRxMQTTBroker() {
+	b := newMQTTBroker()
+	b.authorize = func(s *mqttSession) byte {
+		if s.clientId == rxPublisherId && subtle.ConstantTimeCompare([]byte(s.password), []byte(rxPassword)) != 1 {
+			return 5 // not authorized -> Tx의 ID로 연결해 Tx의 연결을 끊을 수도 없음
+		}
+		return 0
+	}
+	b.canPublish = func(s *mqttSession, topic string) bool {
+		return topic != rxPackageTopic() || s.clientId == rxPublisherId
+	}
+	b.onPublish = func(m mqttMessage) {
+		if m.topic == rxPackageTopic() {
+			receiveRxPackage(m)
+		}
+	}
+	startMQTTBridge(b.route, "embedded MQTT broker")
+	b.listen(":" + mqttPort)
+}
+
+// 브로커의 연결 고루틴에서 호출 -> Tx의 연결은 하나이므로 받은 순서 = 커밋 순서
+func receiveRxPackage(m mqttMessage) {
+	start := time.Now()
+	dataPackage, err := decodeRxPackage(m.payload)
+	if err != nil {
+		log.Printf("Error unmarshaling protobuf data: %v", err)
+		return
+	}
+	applyRxPackage(dataPackage, m.dup)
+
+	log.Printf("Rx server received %d bytes from Tx server. (protobuf, MQTT) \n", len(m.payload))
+	logRxPackage(dataPackage)
+	fmt.Printf("-- Rx_Time elapsed for MQTT Applying: %d ms.\n", time.Since(start).Milliseconds())
+}
+
+// 수신은 병렬로 하되, RxData 반영은 연결을 받은 순서대로 하기 위한 순번
+// -> Tx는 커밋한 순서대로 하나씩 전송하므로, 받은 순서 = 커밋 순서
+type applyOrder struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	next uint64
+}
+
+var rxApplyOrder = newApplyOrder()
+
+func newApplyOrder() *applyOrder {
+	o := &applyOrder{}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// 앞선 연결들이 모두 끝날 때까지 대기
+func (o *applyOrder) wait(turn uint64) {
+	o.mu.Lock()
+	for o.next != turn {
+		o.cond.Wait()
 	}
+	o.mu.Unlock()
+}
+
+// 다음 순번으로 넘김 (수신에 실패한 연결도 반드시 호출해야 뒤의 연결이 멈추지 않음)
+func (o *applyOrder) done(turn uint64) {
+	o.wait(turn)
+	o.mu.Lock()
+	o.next++
+	o.cond.Broadcast()
+	o.mu.Unlock()
 }
 
-func handleRxConn(conn net.Conn) {
+func handleRxConn(conn net.Conn, turn uint64) {
 	defer conn.Close()
+	defer rxApplyOrder.done(turn)
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second)) // 멈춘 연결이 뒤의 연결을 계속 막지 않도록
 
 	// 데이터 길이 수신
 	// -> 4바이트로 설정하지 않으면 Error reading from connection: EOF
@@ -330,36 +5327,108 @@ func handleRxConn(conn net.Conn) {
 	end := time.Since(start)
 
 	// Protobuf 메시지 디코딩: 네트워크를 통해 수신한 바이트 데이터를 Protobuf 객체로 디코딩
-	var dataPackage pt.DataPackage
-	if err := proto.Unmarshal(buf, &dataPackage); err != nil {
+	dataPackage, err := decodeRxPackage(buf)
+	if err != nil {
 		log.Printf("Error unmarshaling protobuf data: %v", err)
 		return
 	}
 
-	// TotalCount vs 수신 데이터의 개수
-	if int(dataPackage.TotalCount) == len(dataPackage.DataList) {
+	rxApplyOrder.wait(turn)
+	applyRxPackage(dataPackage, false)
+
+	// 수신된 데이터 바이트 수 출력
+	log.Printf("Rx server received %d bytes from Tx server. (protobuf) \n", totalRead)
+	logRxPackage(dataPackage)
+	fmt.Printf("-- Rx_Time elapsed for Socket Receiving: %d ms.\n", end.Milliseconds())
+}
+
+func decodeRxPackage(buf []byte) (*pt.DataPackage, error) {
+	var dataPackage pt.DataPackage
+	if err := proto.Unmarshal(buf, &dataPackage); err != nil {
+		return nil, err
+	}
+
+	// 이전 버전 Tx가 보낸 데이터도 같은 형태로 맞춤
+	for _, d := range dataPackage.DataList {
+		upgradeData(d)
+	}
+	for _, op := range dataPackage.GetTransaction().GetOperations() {
+		if op.Data != nil {
+			upgradeData(op.Data)
+		}
+	}
+	return &dataPackage, nil
+}
+
+// 받은 패키지를 컬렉션에 반영 (호출하는 쪽에서 받은 순서대로 호출)
+// 현재 버전보다 낮은 패키지는 버림 (늦게 도착했거나 다시 보낸 이전 커밋이 최신 데이터를 되돌리지 않도록)
+// redelivered: MQTT로 다시 받은 패키지 -> 현재 버전과 같아도 이미 반영한 것이므로 건너뜀
+func applyRxPackage(dataPackage *pt.DataPackage, redelivered bool) {
+	name := dataPackage.Collection
+	if name == "" { // 이전 버전 Tx
+		name = defaultCollection
+	}
+	c := rxCollectionFor(name, true)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	beforeVersion := c.version
+	if dataPackage.Version < c.version {
+		log.Printf("Package for version %d is older than current version %d, ignoring.", dataPackage.Version, c.version)
+		return
+	}
+	if redelivered && dataPackage.Version == c.version {
+		log.Printf("Package for version %d was already applied, skipping redelivery.", dataPackage.Version)
+		return
+	}
+	var events []changeEvent
+	if dataPackage.Schema != nil {
+		applyDynamicPackage(c, dataPackage)
+	} else if dataPackage.Transaction != nil && dataPackage.Version != 0 && dataPackage.Version != c.version+1 {
+		// 트랜잭션은 바로 앞 버전에 대한 변경분 -> 중간 패키지를 잃었으면 적용하지 않고 Tx가 보낼 전체 데이터를 기다림
+		// (버전이 0이면 버전을 보내지 않는 이전 버전 Tx)
+		log.Printf("Transaction for version %d does not follow current version %d, keeping current RxData until a full package arrives.", dataPackage.Version, c.version)
+	} else if dataPackage.Transaction != nil {
+		// 트랜잭션 -> c.mu를 잡은 채로 제자리에서 적용하고, 실패하면 되돌림 (일부만 반영된 상태는 노출되지 않음)
+		before, after, undo, failed := applyTransaction(&c.data, c.index, dataPackage.Transaction, nil)
+		if failed != nil {
+			log.Printf("Transaction could not be applied (%v), keeping current RxData.", failed)
+		} else if int(dataPackage.TotalCount) != len(c.data) {
+			undo()
+			log.Printf("Data count mismatch after transaction, keeping current RxData.")
+		} else {
+			log.Printf("Transaction applied, updating RxData.")
+			c.version = dataPackage.Version
+			events = diffChanges(before, after, c.version)
+		}
+	} else if errs := validatePackage(dataPackage.DataList); len(errs) > 0 {
+		// 검증 실패 -> 기존 RxData 유지
+		log.Printf("Invalid data in package (%s), keeping current RxData.", strings.Join(errs, "; "))
+	} else if int(dataPackage.TotalCount) == len(dataPackage.DataList) { // TotalCount vs 수신 데이터의 개수
 		// 개수 일치 -> Tx에서 송신한 데이터를 Rx에 반영
 		log.Printf("Data count matches, updating RxData with received data.")
-		RxData = dataPackage.DataList
+		before := c.data
+		c.data = keepTombstones(c.data, dataPackage.DataList)
+		c.index = indexData(c.data)
+		c.version = dataPackage.Version
+		events = diffChanges(before, c.data, c.version)
 	} else {
 		// 개수 불일치 -> 기존 RxData 유지
 		log.Printf("Data count mismatch, keeping current RxData.")
 	}
+	if c.version != beforeVersion { // 동적 스키마 컬렉션은 버전만 알림 (events 없음)
+		c.feed.publish(c.version, events)
+	}
+}
 
+func logRxPackage(dataPackage *pt.DataPackage) {
 	// Protobuf 객체를 JSON으로 변환
-	jsonData, err := protojson.Marshal(&dataPackage)
+	jsonData, err := marshalCanonical(dataPackage)
 	if err != nil {
 		log.Printf("Error converting protobuf to JSON: %v", err)
 		return
 	}
-
-	// 수신된 데이터 바이트 수 출력
-	log.Printf("Rx server received %d bytes from Tx server. (protobuf) \n", totalRead)
 	log.Printf("Rx server received data: %s\n", string(jsonData))
-	fmt.Printf("-- Rx_Time elapsed for Socket Receiving: %d ms.\n", end.Milliseconds())
-
-	//rxDataMutex.Lock()
-	//rxDataMutex.Unlock()
 }
 
 // 서버가 종료될 때 모든 고루틴이 종료될 때까지 기다려야 하는 경우 -> 웨이트그룹 사용
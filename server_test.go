@@ -0,0 +1,732 @@
+package main
+
+// 세 프로그램이 한 디렉터리에 있으므로 서버 파일과 함께 실행
+// -> go test server.go server_test.go
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"prototest/pt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestReadMQTTPacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		header  byte
+		body    []byte
+		wantErr string
+	}{
+		{name: "empty body", input: []byte{mqttPingreq << 4, 0x00}, header: mqttPingreq << 4, body: []byte{}},
+		{name: "one byte length", input: []byte{0x30, 0x02, 'a', 'b'}, header: 0x30, body: []byte("ab")},
+		{name: "largest one byte length", input: append([]byte{0x30, 0x7f}, bytes.Repeat([]byte{'x'}, 127)...), header: 0x30, body: bytes.Repeat([]byte{'x'}, 127)},
+		{name: "two byte length", input: append([]byte{0x30, 0x80, 0x01}, bytes.Repeat([]byte{'x'}, 128)...), header: 0x30, body: bytes.Repeat([]byte{'x'}, 128)},
+		{name: "trailing bytes are left for the next packet", input: []byte{0x40, 0x02, 0x00, 0x01, 0xd0, 0x00}, header: 0x40, body: []byte{0x00, 0x01}},
+		{name: "no header", input: []byte{}, wantErr: "EOF"},
+		{name: "truncated length", input: []byte{0x30, 0x80}, wantErr: "EOF"},
+		{name: "truncated body", input: []byte{0x30, 0x05, 'a', 'b'}, wantErr: "unexpected EOF"},
+		{name: "length longer than four bytes", input: []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, wantErr: "malformed remaining length"},
+		{name: "oversize", input: []byte{0x30, 0xff, 0xff, 0xff, 0x7f}, wantErr: "packet too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, body, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(tt.input)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if header != tt.header || !bytes.Equal(body, tt.body) {
+				t.Errorf("got header %#x body %q, want %#x %q", header, body, tt.header, tt.body)
+			}
+		})
+	}
+}
+
+// mqttPacket이 만든 남은 길이를 readMQTTPacket이 그대로 읽는지 (1~4바이트 경계)
+func TestMQTTPacketRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152} {
+		body := bytes.Repeat([]byte{'x'}, size)
+		header, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(mqttPacket(0x30, body))))
+		if err != nil || header != 0x30 || len(got) != size {
+			t.Errorf("size %d: got header %#x, %d bytes, error %v", size, header, len(got), err)
+		}
+	}
+}
+
+func TestReadRedisCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{name: "inline", input: "PING\r\n", want: []string{"PING"}},
+		{name: "inline with extra spaces", input: "GET   data:1 \r\n", want: []string{"GET", "data:1"}},
+		{name: "inline without CR", input: "DBSIZE\n", want: []string{"DBSIZE"}},
+		{name: "array", input: "*2\r\n$3\r\nGET\r\n$6\r\ndata:1\r\n", want: []string{"GET", "data:1"}},
+		{name: "bulk with CRLF inside", input: "*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", want: []string{"ECHO", "a\r\nb"}},
+		{name: "empty bulk", input: "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", want: []string{"ECHO", ""}},
+		{name: "empty array", input: "*0\r\n", want: []string{}},
+		{name: "no input", input: "", wantErr: "EOF"},
+		{name: "invalid multibulk length", input: "*x\r\n", wantErr: "invalid multibulk length"},
+		{name: "too many arguments", input: "*1025\r\n", wantErr: "invalid multibulk length"},
+		{name: "missing bulk prefix", input: "*1\r\nGET\r\n", wantErr: "expected '$'"},
+		{name: "non-numeric bulk length", input: "*1\r\n$abc\r\n", wantErr: "invalid bulk length"},
+		{name: "negative bulk length", input: "*1\r\n$-1\r\n", wantErr: "invalid bulk length"},
+		{name: "oversize bulk", input: "*1\r\n$1048577\r\n", wantErr: "invalid bulk length"},
+		{name: "truncated bulk", input: "*1\r\n$5\r\nab\r\n", wantErr: "unexpected EOF"},
+		{name: "missing arguments", input: "*2\r\n$4\r\nPING\r\n", wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRedisCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, error %v, want %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 테스트용 레코드 (deleted면 툼스톤)
+func testRecord(id, version int64, deleted bool) *pt.Data {
+	d := &pt.Data{Id: id, Name: fmt.Sprintf("name%d", id), Version: version}
+	if deleted {
+		d.DeletedAt = timestamppb.Now()
+	}
+	return d
+}
+
+func testOp(kind pt.Operation_Kind, d *pt.Data) *pt.Operation {
+	return &pt.Operation{Kind: kind, Data: d}
+}
+
+// 비교하기 쉽도록 레코드를 "id:v버전:이름" (툼스톤은 뒤에 :deleted)으로
+func describeData(dataList []*pt.Data) []string {
+	described := make([]string, len(dataList))
+	for i, d := range dataList {
+		described[i] = fmt.Sprintf("%d:v%d:%s", d.Id, d.Version, d.Name)
+		if d.DeletedAt != nil {
+			described[i] += ":deleted"
+		}
+	}
+	return described
+}
+
+// index가 data의 위치와 정확히 맞는지
+func checkIndex(t *testing.T, data []*pt.Data, index map[int64]int) {
+	t.Helper()
+	if !maps.Equal(index, indexData(data)) {
+		t.Errorf("index %v does not match data %v", index, describeData(data))
+	}
+}
+
+func TestApplyTransaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      []*pt.Operation
+		rx       bool    // Rx처럼 expected 없이 적용
+		expected []int64 // 작업별 기대 버전 (nil이면 모두 0)
+		want     []string
+		failed   string // 실패한 작업의 outcome -> 데이터와 index는 처음 그대로여야 함
+	}{
+		{
+			name: "create appends with version 1",
+			ops:  []*pt.Operation{testOp(pt.Operation_CREATE, testRecord(4, 0, false))},
+			want: []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3", "4:v1:name4"},
+		},
+		{
+			name: "create over a tombstone continues its version",
+			ops:  []*pt.Operation{testOp(pt.Operation_CREATE, testRecord(2, 0, false))},
+			want: []string{"1:v1:name1", "2:v4:name2", "3:v2:name3"},
+		},
+		{
+			name: "update and delete bump versions",
+			ops:  []*pt.Operation{testOp(pt.Operation_UPDATE, testRecord(1, 0, false)), testOp(pt.Operation_DELETE, testRecord(3, 0, false))},
+			want: []string{"1:v2:name1", "2:v3:name2:deleted", "3:v3:name3:deleted"},
+		},
+		{
+			name: "purge compacts the data",
+			ops:  []*pt.Operation{testOp(pt.Operation_PURGE, testRecord(2, 0, false))},
+			want: []string{"1:v1:name1", "3:v2:name3"},
+		},
+		{
+			name: "restore revives a tombstone",
+			ops:  []*pt.Operation{testOp(pt.Operation_RESTORE, testRecord(2, 0, false))},
+			want: []string{"1:v1:name1", "2:v4:name2", "3:v2:name3"},
+		},
+		{
+			name:   "create of a live id rolls back earlier operations",
+			ops:    []*pt.Operation{testOp(pt.Operation_CREATE, testRecord(4, 0, false)), testOp(pt.Operation_CREATE, testRecord(1, 0, false))},
+			failed: outcomeExists,
+		},
+		{
+			name:   "update of a tombstone is not found",
+			ops:    []*pt.Operation{testOp(pt.Operation_UPDATE, testRecord(1, 0, false)), testOp(pt.Operation_UPDATE, testRecord(2, 0, false))},
+			failed: outcomeNotFound,
+		},
+		{
+			name:   "restore of a live record is not found",
+			ops:    []*pt.Operation{testOp(pt.Operation_RESTORE, testRecord(1, 0, false))},
+			failed: outcomeNotFound,
+		},
+		{
+			name:     "version mismatch conflicts",
+			ops:      []*pt.Operation{testOp(pt.Operation_UPDATE, testRecord(1, 0, false)), testOp(pt.Operation_UPDATE, testRecord(3, 0, false))},
+			expected: []int64{1, 5},
+			failed:   outcomeConflict,
+		},
+		{
+			name:   "invalid record",
+			ops:    []*pt.Operation{testOp(pt.Operation_CREATE, &pt.Data{Id: 4})},
+			failed: outcomeInvalid,
+		},
+		{
+			name:   "operation without data",
+			ops:    []*pt.Operation{{Kind: pt.Operation_DELETE}},
+			failed: outcomeInvalid,
+		},
+		{
+			name:   "purge rolled back after compaction restores the index",
+			ops:    []*pt.Operation{testOp(pt.Operation_PURGE, testRecord(2, 0, false)), testOp(pt.Operation_DELETE, testRecord(1, 0, false)), testOp(pt.Operation_UPDATE, testRecord(9, 0, false))},
+			failed: outcomeNotFound,
+		},
+		{
+			name: "rx ignores a stale record for a tombstone",
+			ops:  []*pt.Operation{testOp(pt.Operation_UPDATE, testRecord(2, 3, false)), testOp(pt.Operation_UPDATE, testRecord(1, 2, false))},
+			rx:   true,
+			want: []string{"1:v2:name1", "2:v3:name2:deleted", "3:v2:name3"},
+		},
+		{
+			name: "rx removes a record deleted by a legacy tx",
+			ops:  []*pt.Operation{testOp(pt.Operation_DELETE, &pt.Data{Id: 1})},
+			rx:   true,
+			want: []string{"2:v3:name2:deleted", "3:v2:name3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []*pt.Data{testRecord(1, 1, false), testRecord(2, 3, true), testRecord(3, 2, false)}
+			index := indexData(data)
+			initial := describeData(data)
+			expected := tt.expected
+			if expected == nil && !tt.rx {
+				expected = make([]int64, len(tt.ops))
+			}
+			_, _, undo, failed := applyTransaction(&data, index, &pt.Transaction{Operations: tt.ops}, expected)
+			checkIndex(t, data, index)
+			if tt.failed != "" {
+				if failed == nil || failed.Outcome != tt.failed {
+					t.Fatalf("got failure %+v, want %s", failed, tt.failed)
+				}
+				if got := describeData(data); !slices.Equal(got, initial) {
+					t.Errorf("data after rollback = %v, want %v", got, initial)
+				}
+				return
+			}
+			if failed != nil {
+				t.Fatalf("unexpected failure: %+v", failed)
+			}
+			if got := describeData(data); !slices.Equal(got, tt.want) {
+				t.Errorf("data = %v, want %v", got, tt.want)
+			}
+			// 커밋 후에도 (Rx의 개수 확인 실패 등) 되돌리면 처음 상태와 index로 돌아가야 함
+			undo()
+			checkIndex(t, data, index)
+			if got := describeData(data); !slices.Equal(got, initial) {
+				t.Errorf("data after undo = %v, want %v", got, initial)
+			}
+		})
+	}
+}
+
+func TestWriteTxData(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		dup      string
+		lastId   int64
+		input    []sData
+		outcomes []string
+		want     []string
+	}{
+		{
+			name:     "replace keeps versions of rewritten ids and tombstones the rest",
+			mode:     writeReplace,
+			input:    []sData{{Id: 1, Name: "a"}, {Id: 2, Name: "b"}, {Id: 4, Name: "d"}},
+			outcomes: []string{outcomeCreated, outcomeCreated, outcomeCreated},
+			want:     []string{"1:v2:a", "2:v4:b", "3:v3:name3:deleted", "4:v1:d"},
+		},
+		{
+			name:     "replace where every item fails keeps the data",
+			mode:     writeReplace,
+			input:    []sData{{Id: 1}, {Id: -1, Name: "x"}},
+			outcomes: []string{outcomeInvalid, outcomeInvalid},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3"},
+		},
+		{
+			name: "replace with an empty list deletes everything",
+			mode: writeReplace,
+			want: []string{"1:v2:name1:deleted", "2:v3:name2:deleted", "3:v3:name3:deleted"},
+		},
+		{
+			name:     "append rejects live ids and assigns ids above the largest one",
+			mode:     writeAppend,
+			lastId:   3,
+			input:    []sData{{Id: 1, Name: "a"}, {Name: "new"}, {Id: 10, Name: "ten"}},
+			outcomes: []string{outcomeExists, outcomeCreated, outcomeCreated},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3", "11:v1:new", "10:v1:ten"},
+		},
+		{
+			name:     "upsert updates live ids and recreates tombstones",
+			mode:     writeUpsert,
+			input:    []sData{{Id: 1, Name: "a"}, {Id: 2, Name: "b"}, {Id: 5, Name: "e"}},
+			outcomes: []string{outcomeUpdated, outcomeCreated, outcomeCreated},
+			want:     []string{"1:v2:a", "2:v4:b", "3:v2:name3", "5:v1:e"},
+		},
+		{
+			name:     "update only touches live ids",
+			mode:     writeUpdate,
+			input:    []sData{{Id: 3, Name: "c"}, {Id: 2, Name: "b"}, {Id: 7, Name: "g"}},
+			outcomes: []string{outcomeUpdated, outcomeNotFound, outcomeNotFound},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v3:c"},
+		},
+		{
+			name:     "merge keeps fields that were not sent",
+			mode:     writeMerge,
+			input:    []sData{{Id: 1, Address: "Seoul"}, {Id: 9, Name: "i"}},
+			outcomes: []string{outcomeUpdated, outcomeNotFound},
+			want:     []string{"1:v2:name1", "2:v3:name2:deleted", "3:v2:name3"},
+		},
+		{
+			name:     "expected version must match",
+			mode:     writeUpdate,
+			input:    []sData{{Id: 1, Name: "a", Version: 5}, {Id: 3, Name: "c", Version: 2}},
+			outcomes: []string{outcomeConflict, outcomeUpdated},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v3:c"},
+		},
+		{
+			name:     "dup first keeps the first item",
+			mode:     writeUpsert,
+			dup:      dupFirst,
+			input:    []sData{{Id: 5, Name: "x"}, {Id: 5, Name: "y"}},
+			outcomes: []string{outcomeCreated, outcomeDuplicate},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3", "5:v1:x"},
+		},
+		{
+			name:     "dup last keeps the last item",
+			mode:     writeUpsert,
+			dup:      dupLast,
+			input:    []sData{{Id: 5, Name: "x"}, {Id: 5, Name: "y"}},
+			outcomes: []string{outcomeDuplicate, outcomeCreated},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3", "5:v1:y"},
+		},
+		{
+			name:     "dup reject skips every copy",
+			mode:     writeUpsert,
+			dup:      dupReject,
+			input:    []sData{{Id: 5, Name: "x"}, {Id: 5, Name: "y"}, {Id: 6, Name: "z"}},
+			outcomes: []string{outcomeDuplicate, outcomeDuplicate, outcomeCreated},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3", "6:v1:z"},
+		},
+		{
+			name:     "client ids above the ceiling are rejected",
+			mode:     writeAppend,
+			lastId:   3,
+			input:    []sData{{Id: math.MaxInt64, Name: "max"}, {Name: "next"}},
+			outcomes: []string{outcomeInvalid, outcomeCreated},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3", "4:v1:next"},
+		},
+		{
+			name:     "no id is assigned once the sequence is exhausted",
+			mode:     writeAppend,
+			lastId:   math.MaxInt64,
+			input:    []sData{{Name: "next"}},
+			outcomes: []string{outcomeInvalid},
+			want:     []string{"1:v1:name1", "2:v3:name2:deleted", "3:v2:name3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTxCollection("test")
+			c.data = []*pt.Data{testRecord(1, 1, false), testRecord(2, 3, true), testRecord(3, 2, false)}
+			c.index = indexData(c.data)
+			c.lastId = tt.lastId
+			results := writeTxData(c, tt.input, tt.mode, cmp.Or(tt.dup, dupFirst))
+			outcomes := make([]string, len(results))
+			for i, res := range results {
+				outcomes[i] = res.Outcome
+			}
+			if !slices.Equal(outcomes, tt.outcomes) {
+				t.Errorf("outcomes = %v, want %v", outcomes, tt.outcomes)
+			}
+			if got := describeData(c.data); !slices.Equal(got, tt.want) {
+				t.Errorf("data = %v, want %v", got, tt.want)
+			}
+			checkIndex(t, c.data, c.index)
+		})
+	}
+}
+
+func TestApplyRxPackage(t *testing.T) {
+	createTx := func(id int64) *pt.Transaction {
+		return &pt.Transaction{Operations: []*pt.Operation{testOp(pt.Operation_CREATE, testRecord(id, 1, false))}}
+	}
+	tests := []struct {
+		name        string
+		pkg         *pt.DataPackage
+		redelivered bool
+		version     uint64
+		want        []string
+	}{
+		{
+			name:    "older package is ignored",
+			pkg:     &pt.DataPackage{Version: 4, TotalCount: 1, DataList: []*pt.Data{testRecord(7, 1, false)}},
+			version: 5,
+			want:    []string{"1:v1:name1", "2:v3:name2:deleted"},
+		},
+		{
+			name:        "redelivered package is skipped",
+			pkg:         &pt.DataPackage{Version: 5, TotalCount: 1, DataList: []*pt.Data{testRecord(7, 1, false)}},
+			redelivered: true,
+			version:     5,
+			want:        []string{"1:v1:name1", "2:v3:name2:deleted"},
+		},
+		{
+			name:    "transaction after a missing version is not applied",
+			pkg:     &pt.DataPackage{Version: 7, TotalCount: 3, Transaction: createTx(3)},
+			version: 5,
+			want:    []string{"1:v1:name1", "2:v3:name2:deleted"},
+		},
+		{
+			name:    "transaction for the next version is applied",
+			pkg:     &pt.DataPackage{Version: 6, TotalCount: 3, Transaction: createTx(3)},
+			version: 6,
+			want:    []string{"1:v1:name1", "2:v3:name2:deleted", "3:v1:name3"},
+		},
+		{
+			name:    "transaction with a wrong total count is undone",
+			pkg:     &pt.DataPackage{Version: 6, TotalCount: 5, Transaction: createTx(3)},
+			version: 5,
+			want:    []string{"1:v1:name1", "2:v3:name2:deleted"},
+		},
+		{
+			name:    "full package keeps a newer tombstone",
+			pkg:     &pt.DataPackage{Version: 9, TotalCount: 2, DataList: []*pt.Data{testRecord(1, 2, false), testRecord(2, 3, false)}},
+			version: 9,
+			want:    []string{"1:v2:name1", "2:v3:name2:deleted"},
+		},
+		{
+			name:    "full package revives a tombstone with a newer version",
+			pkg:     &pt.DataPackage{Version: 9, TotalCount: 2, DataList: []*pt.Data{testRecord(1, 1, false), testRecord(2, 4, false)}},
+			version: 9,
+			want:    []string{"1:v1:name1", "2:v4:name2"},
+		},
+		{
+			name:    "full package with a wrong total count is not applied",
+			pkg:     &pt.DataPackage{Version: 9, TotalCount: 3, DataList: []*pt.Data{testRecord(1, 1, false)}},
+			version: 5,
+			want:    []string{"1:v1:name1", "2:v3:name2:deleted"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pkg.Collection = fmt.Sprintf("test-rx-%d", i) // 케이스마다 새 컬렉션
+			c := rxCollectionFor(tt.pkg.Collection, true)
+			c.data = []*pt.Data{testRecord(1, 1, false), testRecord(2, 3, true)}
+			c.index = indexData(c.data)
+			c.version = 5
+			applyRxPackage(tt.pkg, tt.redelivered)
+			if c.version != tt.version {
+				t.Errorf("version = %d, want %d", c.version, tt.version)
+			}
+			if got := describeData(c.data); !slices.Equal(got, tt.want) {
+				t.Errorf("data = %v, want %v", got, tt.want)
+			}
+			checkIndex(t, c.data, c.index)
+		})
+	}
+}
+
+func TestSnapshotAt(t *testing.T) {
+	defer func(limit int) { historyLimit = limit }(historyLimit)
+	historyLimit = 20
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	c := newTxCollection("test")
+	for _, entry := range []struct {
+		id     int64
+		record *pt.Data
+	}{
+		{1, testRecord(1, 1, false)},
+		{2, testRecord(2, 1, false)},
+		{1, testRecord(1, 2, true)},
+	} {
+		c.version++
+		appendHistory(c, entry.id, historyEntry{DatasetVersion: c.version, At: start.Add(time.Duration(c.version) * time.Second), record: entry.record})
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		floor   uint64
+		version uint64
+		want    []string
+		status  int
+	}{
+		{name: "first version", query: "version=1", version: 1, want: []string{"1:v1:name1"}},
+		{name: "second version", query: "version=2", version: 2, want: []string{"1:v1:name1", "2:v1:name2"}},
+		{name: "deleted records are left out", query: "version=3", version: 3, want: []string{"2:v1:name2"}},
+		{name: "by time", query: "at=2024-01-02T15:00:02.5Z", version: 2, want: []string{"1:v1:name1", "2:v1:name2"}},
+		{name: "before the first change", query: "at=2024-01-02T14:00:00Z", version: 0, want: []string{}},
+		{name: "newer than current", query: "version=4", status: http.StatusNotFound},
+		{name: "below the history floor", query: "version=1", floor: 2, status: http.StatusGone},
+		{name: "version and at together", query: "version=1&at=2024-01-02T15:00:00Z", status: http.StatusBadRequest},
+		{name: "neither version nor at", query: "", status: http.StatusBadRequest},
+		{name: "invalid version", query: "version=x", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.historyFloor = tt.floor
+			query, _ := url.ParseQuery(tt.query)
+			dataList, version, status, err := snapshotAt(c, query)
+			if tt.status != 0 {
+				if err == nil || status != tt.status {
+					t.Fatalf("got status %d, error %v, want %d", status, err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := describeData(dataList); version != tt.version || !slices.Equal(got, tt.want) {
+				t.Errorf("got version %d %v, want %d %v", version, got, tt.version, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeFeedSince(t *testing.T) {
+	defer func(limit int) { feedLimit = limit }(feedLimit)
+	feedLimit = 3
+	var f changeFeed
+	for version := uint64(1); version <= 4; version++ {
+		f.publish(version, []changeEvent{{Version: version, Op: opUpdate, Id: 1}})
+	}
+
+	tests := []struct {
+		last uint64
+		want []uint64 // 이어받는 이벤트의 버전
+		ok   bool
+	}{
+		{last: 0, ok: false}, // 버전 1의 이벤트는 잘려 나감
+		{last: 1, want: []uint64{2, 3, 4}, ok: true},
+		{last: 3, want: []uint64{4}, ok: true},
+		{last: 4, want: []uint64{}, ok: true},
+		{last: 5, ok: false}, // 현재보다 새 버전
+	}
+	for _, tt := range tests {
+		events, current, _, ok := f.since(tt.last)
+		versions := make([]uint64, len(events))
+		for i, e := range events {
+			versions[i] = e.Version
+		}
+		if ok != tt.ok || current != 4 || !slices.Equal(versions, tt.want) {
+			t.Errorf("since(%d) = %v, %d, %v, want %v, 4, %v", tt.last, versions, current, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// 커서로 끝까지 넘기면서 받은 ID (페이지 순서대로)
+func collectPages(t *testing.T, dataList []*pt.Data, query string) [][]int64 {
+	t.Helper()
+	values, _ := url.ParseQuery(query)
+	var pages [][]int64
+	for {
+		q, err := parseListQuery(values)
+		if err != nil {
+			t.Fatalf("parseListQuery(%q): %v", values.Encode(), err)
+		}
+		page := q.apply(dataList)
+		ids := make([]int64, len(page.data))
+		for i, d := range page.data {
+			ids[i] = d.Id
+		}
+		pages = append(pages, ids)
+		if page.next == "" {
+			return pages
+		}
+		values.Set("cursor", page.next)
+	}
+}
+
+func TestListQueryCursor(t *testing.T) {
+	var dataList []*pt.Data
+	for i, name := range []string{"c", "a", "b", "a", "c"} {
+		d := testRecord(int64(i+1), 1, false)
+		d.Name = name
+		dataList = append(dataList, d)
+	}
+
+	tests := []struct {
+		query string
+		want  [][]int64
+	}{
+		{query: "limit=2", want: [][]int64{{1, 2}, {3, 4}, {5}}},
+		{query: "limit=2&sort=name", want: [][]int64{{2, 4}, {3, 1}, {5}}},
+		{query: "limit=2&sort=-name", want: [][]int64{{5, 1}, {3, 4}, {2}}},
+		{query: "limit=2&name_prefix=c", want: [][]int64{{1, 5}}},
+		{query: "limit=3&id_min=2&id_max=4", want: [][]int64{{2, 3, 4}}},
+		{query: "", want: [][]int64{{1, 2, 3, 4, 5}}},
+	}
+	for _, tt := range tests {
+		if got := collectPages(t, dataList, tt.query); !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("%q: pages = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// 다음 페이지 전에 앞쪽 레코드가 삭제되어도 값으로 이어가므로 빠지는 항목이 없음
+	q, _ := parseListQuery(url.Values{"limit": {"2"}})
+	next := q.apply(dataList).next
+	q, err := parseListQuery(url.Values{"limit": {"2"}, "cursor": {next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page := q.apply(dataList[1:]); len(page.data) != 2 || page.data[0].Id != 3 {
+		t.Errorf("page after deleting id 1 = %v, want ids 3, 4", describeData(page.data))
+	}
+
+	for _, values := range []url.Values{
+		{"limit": {"2"}, "sort": {"name"}, "cursor": {next}}, // 다른 정렬로 발급된 커서
+		{"cursor": {"not-base64!"}},
+		{"limit": {"0"}},
+		{"sort": {"address"}},
+	} {
+		if _, err := parseListQuery(values); err == nil {
+			t.Errorf("parseListQuery(%q) succeeded, want error", values.Encode())
+		}
+	}
+}
+
+func TestDecodeCSV(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		input   string
+		want    []sData
+		wantErr string
+	}{
+		{
+			name:  "only some columns",
+			input: "name,id\nAlice,1\nBob,\n",
+			want:  []sData{{Id: 1, Name: "Alice"}, {Name: "Bob"}},
+		},
+		{
+			name:  "all request columns",
+			input: "id,name,address,sex,version,expires_at,ttl,attributes\n3,Carol,\"Seoul, Korea\",Female,2,2024-01-02T15:04:05Z,,team=a;role=b\n",
+			want:  []sData{{Id: 3, Name: "Carol", Address: "Seoul, Korea", Sex: "Female", Version: 2, ExpiresAt: &expiresAt, Attributes: map[string]string{"team": "a", "role": "b"}}},
+		},
+		{
+			name:  "managed timestamps are ignored",
+			input: "id,name,created_at,updated_at\n1,Alice,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z\n",
+			want:  []sData{{Id: 1, Name: "Alice"}},
+		},
+		{name: "header only", input: "id,name\n", want: nil},
+		{name: "empty body", input: "", wantErr: "missing header row"},
+		{name: "unknown column", input: "id,nickname\n1,x\n", wantErr: `unknown column "nickname"`},
+		{name: "invalid id", input: "id,name\nx,Alice\n", wantErr: "line 2, column id"},
+		{name: "invalid expires_at", input: "id,expires_at\n1,tomorrow\n", wantErr: "line 2, column expires_at"},
+		{name: "attribute without =", input: "id,attributes\n1,team\n", wantErr: `attribute "team" must be key=value`},
+		{name: "wrong number of fields", input: "id,name\n1,Alice,extra\n", wantErr: "wrong number of fields"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCSV(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 서명 검증은 받는 쪽과 같은 방법으로 -> HMAC-SHA256(secret, "<timestamp>.<본문>")
+func TestPostWebhookSignature(t *testing.T) {
+	var status int
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook := &webhook{Id: "wh_test", URL: server.URL, Secret: "s3cret"}
+	event := changeEvent{Version: 3, Op: opInsert, Id: 1}
+	payload := []byte(`{"version":3,"op":"insert","id":1}`)
+	tests := []struct {
+		status  int
+		retry   bool
+		wantErr bool
+	}{
+		{status: http.StatusNoContent},
+		{status: http.StatusBadRequest, wantErr: true},
+		{status: http.StatusTooManyRequests, retry: true, wantErr: true},
+		{status: http.StatusBadGateway, retry: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		status = tt.status
+		retry, err := postWebhook(hook, event, payload, "delivery-1")
+		if retry != tt.retry || (err != nil) != tt.wantErr {
+			t.Errorf("status %d: got retry %v, error %v", tt.status, retry, err)
+		}
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write([]byte(header.Get("X-Webhook-Timestamp") + "."))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get("X-Webhook-Signature") != want {
+			t.Errorf("signature = %q, want %q", header.Get("X-Webhook-Signature"), want)
+		}
+		if !bytes.Equal(body, payload) || header.Get("X-Webhook-Event") != opInsert || header.Get("X-Webhook-Delivery") != "delivery-1" {
+			t.Errorf("got body %s, headers %v", body, header)
+		}
+	}
+}
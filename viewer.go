@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
@@ -8,32 +9,321 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"prototest/pt"
 )
 
 type vData struct {
-	Id      int    `json:"id"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Sex     string `json:"sex"`
+	Id         int64             `json:"id"`
+	Name       string            `json:"name"`
+	Address    string            `json:"address"`
+	Sex        string            `json:"sex"`
+	Version    int64             `json:"version"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Attributes map[string]string `json:"attributes"`
+	ExpiresAt  *time.Time        `json:"expires_at"` // 만료 없는 데이터는 nil
+}
+
+// -legacy_json: 서버가 -legacy_json으로 실행 중이면 예전 형식 (vData 배열)으로 읽음
+var legacyJSON bool
+
+var sexNames = map[pt.Sex]string{
+	pt.Sex_SEX_MALE:   "Male",
+	pt.Sex_SEX_FEMALE: "Female",
+	pt.Sex_SEX_OTHER:  "Other",
+}
+
+// 서버의 표준 JSON (protojson으로 만든 Data 배열) -> 출력용 vData
+func parseCanonical(body []byte) ([]vData, error) {
+	var rawList []json.RawMessage
+	if err := json.Unmarshal(body, &rawList); err != nil {
+		return nil, err
+	}
+	data := make([]vData, len(rawList))
+	for i, raw := range rawList {
+		var err error
+		if data[i], err = parseRecord(raw); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i, err)
+		}
+	}
+	return data, nil
 }
 
+// 레코드 하나 (-legacy_json이면 예전 형식)
+func parseRecord(raw []byte) (vData, error) {
+	var data vData
+	if legacyJSON {
+		err := json.Unmarshal(raw, &data)
+		return data, err
+	}
+	d := &pt.Data{}
+	if err := protojson.Unmarshal(raw, d); err != nil {
+		return data, err
+	}
+	data = vData{
+		Id:         d.Id,
+		Name:       d.Name,
+		Address:    d.Address,
+		Sex:        sexNames[d.Sex],
+		Version:    d.Version,
+		CreatedAt:  d.CreatedAt.AsTime(),
+		UpdatedAt:  d.UpdatedAt.AsTime(),
+		Attributes: d.Attributes,
+	}
+	if d.ExpiresAt != nil {
+		expiresAt := d.ExpiresAt.AsTime()
+		data.ExpiresAt = &expiresAt
+	}
+	return data, nil
+}
+
+// 서버의 오류 형식 -> {"error": {"code": ..., "message": ..., "details": ..., "request_id": ...}}
+type errorResponse struct {
+	Error struct {
+		Code      string          `json:"code"`
+		Message   string          `json:"message"`
+		Details   json.RawMessage `json:"details"`
+		RequestId string          `json:"request_id"`
+	} `json:"error"`
+}
+
+// 마지막으로 받은 데이터셋의 ETag -> 다음 요청에 If-None-Match로 보내 변경이 없으면 304
+var lastETag string
+
 func main() {
 	url := flag.String("sv_url", "", "Server URL (tx/rx)")
+	collection := flag.String("collection", "", "Collection to view (default collection if empty)")
+	limit := flag.Int("limit", 0, "Show only the first N records (0 for all)")
+	filter := flag.String("filter", "", "Filters and sort as a query string, e.g. \"sex=Female&name_prefix=Al&sort=-updated_at\" (with -watch, changes are filtered the same way; sort and limit apply to the initial load only)")
+	flag.BoolVar(&legacyJSON, "legacy_json", false, "Parse the old JSON shape (for servers started with -legacy_json)")
+	watch := flag.Bool("watch", false, "Follow the server's change feed (/changes) instead of polling every 10 seconds")
 	flag.Parse()
 
 	if *url == "" {
 		fmt.Println("Error: Server url must be specified.")
 		os.Exit(1)
 	}
+	dataURL, err := collectionURL(*url, *collection)
+	if err == nil {
+		dataURL, err = withListQuery(dataURL, *filter, *limit)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
+	if *watch {
+		feedURL, err := changesURL(*url, *collection)
+		var f recordFilter
+		if err == nil {
+			f, err = parseFilter(*filter)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		watchChanges(dataURL, feedURL, f)
+		return
+	}
 	for {
-		sendGetRequest(*url)
+		sendGetRequest(dataURL)
 		time.Sleep(10 * time.Second)
 	}
 }
 
+// 변경 피드 URL (서버 URL 뒤에 /changes 또는 /collections/{name}/changes)
+func changesURL(serverURL, collection string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %v", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if collection != "" {
+		u.Path += "/collections/" + url.PathEscape(collection)
+	}
+	u.Path += "/changes"
+	return u.String(), nil
+}
+
+// 서버가 보낸 변경 이벤트의 data
+type changeEvent struct {
+	Version uint64          `json:"version"`
+	Op      string          `json:"op"` // insert, update, delete
+	Id      int64           `json:"id"`
+	Record  json.RawMessage `json:"record"`
+}
+
+// -filter의 조건 (서버의 목록 조회와 같은 의미) -> 변경 피드는 서버가 거르지 않으므로 받은 레코드에 직접 적용
+// 갱신되어 조건에서 벗어난 레코드는 더 이상 출력하지 않음 (벗어났다는 이벤트는 따로 없음)
+type recordFilter struct {
+	namePrefix      string
+	sex             string
+	addressContains string // 소문자
+	idMin, idMax    int64  // 0이면 제한 없음
+}
+
+func parseFilter(filter string) (recordFilter, error) {
+	values, err := url.ParseQuery(filter)
+	if err != nil {
+		return recordFilter{}, fmt.Errorf("invalid filter: %v", err)
+	}
+	f := recordFilter{
+		namePrefix:      values.Get("name_prefix"),
+		sex:             values.Get("sex"),
+		addressContains: strings.ToLower(values.Get("address_contains")),
+	}
+	if sex, ok := pt.Sex_value[f.sex]; ok { // enum 이름 (SEX_FEMALE) -> 출력에 쓰는 이름 (Female)
+		f.sex = sexNames[pt.Sex(sex)]
+	}
+	for name, target := range map[string]*int64{"id_min": &f.idMin, "id_max": &f.idMax} {
+		if v := values.Get(name); v != "" {
+			if *target, err = strconv.ParseInt(v, 10, 64); err != nil || *target <= 0 {
+				return recordFilter{}, fmt.Errorf("invalid filter: %s must be a positive integer", name)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f recordFilter) matches(d vData) bool {
+	return strings.HasPrefix(d.Name, f.namePrefix) &&
+		(f.sex == "" || d.Sex == f.sex) &&
+		(f.addressContains == "" || strings.Contains(strings.ToLower(d.Address), f.addressContains)) &&
+		(f.idMin == 0 || d.Id >= f.idMin) &&
+		(f.idMax == 0 || d.Id <= f.idMax)
+}
+
+// 폴링 대신 변경 피드를 따라감 -> 처음에 전체 데이터를 한 번 조회하고, 그 버전 이후의 변경만 받음
+// 연결이 끊기면 마지막으로 받은 이벤트 ID (버전)부터 다시 연결
+func watchChanges(dataURL, feedURL string, f recordFilter) {
+	sendGetRequest(dataURL)
+	lastId := strings.TrimPrefix(strings.Trim(lastETag, "\""), "v")
+	for {
+		lastId = followChanges(feedURL, dataURL, lastId, f)
+		log.Printf("Change feed disconnected, reconnecting from version %s\n", lastId)
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// 연결이 끊길 때까지 이벤트를 출력하고 마지막 이벤트 ID를 반환
+func followChanges(feedURL, dataURL, lastId string, f recordFilter) string {
+	req, err := http.NewRequest(http.MethodGet, feedURL, nil)
+	if err != nil {
+		fmt.Printf("Error creating change feed request: %v\n", err)
+		return lastId
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Error connecting to change feed: %v\n", err)
+		return lastId
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Printf("Error from server: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return lastId
+	}
+	fmt.Printf("Watching changes from %s (after version %s)\n", feedURL, lastId)
+
+	// 빈 줄이 나올 때까지 event:, id:, data: 줄을 모아 이벤트 하나로 처리 (":"로 시작하면 주석)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var event, id, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			if data != "" {
+				printChange(event, data, dataURL, f)
+			}
+			if id != "" {
+				lastId = id
+			}
+			event, id, data = "", "", ""
+		case field == "event":
+			event = value
+		case field == "id":
+			id = value
+		case field == "data":
+			data += value
+		}
+	}
+	return lastId
+}
+
+func printChange(event, data, dataURL string, f recordFilter) {
+	if event == "reset" { // 피드에 남아 있지 않은 버전 -> 전체 데이터를 다시 조회
+		log.Printf("Change feed was reset (%s), reloading data\n", data)
+		lastETag = ""
+		sendGetRequest(dataURL)
+		return
+	}
+	var change changeEvent
+	if err := json.Unmarshal([]byte(data), &change); err != nil {
+		fmt.Printf("Error parsing change event: %v\n", err)
+		return
+	}
+	d, err := parseRecord(change.Record)
+	if err != nil {
+		fmt.Printf("Error parsing changed record: %v\n", err)
+		return
+	}
+	if !f.matches(d) {
+		return
+	}
+	log.Printf("[v%d] %s ID: %d, Name: %s, Address: %s, Sex: %s, Version: %d, Updated: %s, Attributes: %v\n",
+		change.Version, change.Op, d.Id, d.Name, d.Address, d.Sex, d.Version, d.UpdatedAt.Format(time.RFC3339), d.Attributes)
+}
+
+// 컬렉션을 지정하면 서버 URL 뒤에 /collections/{name}/data를 붙임
+func collectionURL(serverURL, collection string) (string, error) {
+	if collection == "" {
+		return serverURL, nil
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %v", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/collections/" + url.PathEscape(collection) + "/data"
+	return u.String(), nil
+}
+
+// 서버에서 필터, 정렬, 개수 제한을 적용하도록 쿼리로 붙임
+func withListQuery(dataURL, filter string, limit int) (string, error) {
+	u, err := url.Parse(dataURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %v", err)
+	}
+	query := u.Query()
+	extra, err := url.ParseQuery(filter)
+	if err != nil {
+		return "", fmt.Errorf("invalid filter: %v", err)
+	}
+	for key, values := range extra {
+		query[key] = values
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
 func sendGetRequest(url string) {
 	// 기본적으로 Go의 http 클라이언트는 자체 서명된 인증서 신뢰 X -> tls: bad certificate 오류 발생
 	tr := &http.Transport{
@@ -45,7 +335,15 @@ func sendGetRequest(url string) {
 
 	// 측정을 시작하는 시간
 	start := time.Now()
-	resp, err := client.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		fmt.Printf("Error creating GET request: %v\n", err)
+		return
+	}
+	if lastETag != "" {
+		req.Header.Set("If-None-Match", lastETag)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Printf("Error sending GET request: %v\n", err)
 		return
@@ -54,32 +352,63 @@ func sendGetRequest(url string) {
 	// 측정을 끝내는 시간
 	end := time.Since(start)
 
+	if resp.StatusCode == http.StatusNotModified {
+		log.Printf("Data not modified since %s\n", lastETag)
+		fmt.Printf("-- Viewer: Time elapsed for GET request: %d ms.\n", end.Milliseconds())
+		return
+	}
+
 	// HTTP 응답의 JSON 데이터를 읽어와 바이트 슬라이스(body)로 저장
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		fmt.Printf("Error reading response body: %v\n", err)
 		return
 	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Code == "" {
+			fmt.Printf("Error from server: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+			return
+		}
+		fmt.Printf("Error from server: %s: %s: %s", resp.Status, errResp.Error.Code, errResp.Error.Message)
+		if len(errResp.Error.Details) > 0 {
+			fmt.Printf(" %s", errResp.Error.Details)
+		}
+		fmt.Printf(" (request %s)\n", errResp.Error.RequestId)
+		return
+	}
 	// 서버에서 받은 원본 JSON 데이터의 바이트 크기
 	// log.Printf("Bytes received from server: %d bytes (json)\n", len(body))
 
 	// HTTP 응답의 body에서 가져온 JSON 데이터를
 	// -> data 구조체 슬라이스로 언마샬링(역직렬화)
 	var data []vData
-	err = json.Unmarshal(body, &data) // &data: data의 포인터, 포인터 전달해 Unmarshal 함수는 data 직접 수정
+	if legacyJSON {
+		err = json.Unmarshal(body, &data) // &data: data의 포인터, 포인터 전달해 Unmarshal 함수는 data 직접 수정
+	} else {
+		data, err = parseCanonical(body)
+	}
 	if err != nil {
 		fmt.Printf("Error parsing response JSON: %v\n", err)
 		return
 	}
 
-	fmt.Println("GET data from Server:")
+	lastETag = resp.Header.Get("ETag")
+	fmt.Printf("GET data from Server (version %s):\n", lastETag)
 	for _, d := range data {
-		log.Printf("ID: %d, Name: %s, Address: %s, Sex: %s\n",
-		 d.Id, d.Name, d.Address, d.Sex)
+		if d.ExpiresAt != nil {
+			log.Printf("ID: %d expires at %s\n", d.Id, d.ExpiresAt.Format(time.RFC3339))
+		}
+		log.Printf("ID: %d, Name: %s, Address: %s, Sex: %s, Version: %d, Updated: %s, Attributes: %v\n",
+			d.Id, d.Name, d.Address, d.Sex, d.Version, d.UpdatedAt.Format(time.RFC3339), d.Attributes)
 	}
 
-	// JSON 데이터를 변환한 후, 구조체 슬라이스 내 요소 개수
-	log.Printf("Number of records: %d\n", len(data))
+	// JSON 데이터를 변환한 후, 구조체 슬라이스 내 요소 개수 (다음 페이지가 있으면 필터에 맞는 전체 개수도)
+	if resp.Header.Get("X-Next-Cursor") != "" {
+		log.Printf("Number of records: %d of %s\n", len(data), resp.Header.Get("X-Total-Count"))
+	} else {
+		log.Printf("Number of records: %d\n", len(data))
+	}
 	// 소요 시간 출력
 	fmt.Printf("-- Viewer: Time elapsed for GET request: %d ms.\n", end.Milliseconds())
 }